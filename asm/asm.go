@@ -0,0 +1,309 @@
+// Package asm is a human-readable assembly form of Whitespace programs:
+// mnemonics like "PUSH 42" and "JMP L1" instead of literal space/tab/newline
+// bytes, so a program can be hand-written or diffed without a whitespace
+// viewer. Assemble and Disassemble convert between that text and a
+// vm.Program; Emit goes the rest of the way and writes the actual
+// Whitespace source a vm.Program represents.
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/kinu/whitespace/vm"
+)
+
+var mnemonics = map[int]string{
+	vm.CMD_PUSH:     "PUSH",
+	vm.CMD_DUP:      "DUP",
+	vm.CMD_COPY:     "COPY",
+	vm.CMD_SWAP:     "SWAP",
+	vm.CMD_DISCARD:  "DISCARD",
+	vm.CMD_SLIDE:    "SLIDE",
+	vm.CMD_ADD:      "ADD",
+	vm.CMD_SUB:      "SUB",
+	vm.CMD_MUL:      "MUL",
+	vm.CMD_DIV:      "DIV",
+	vm.CMD_MOD:      "MOD",
+	vm.CMD_STORE:    "STORE",
+	vm.CMD_RETRIEVE: "RETRIEVE",
+	vm.CMD_MARK:     "MARK",
+	vm.CMD_CALL:     "CALL",
+	vm.CMD_JMP:      "JMP",
+	vm.CMD_JMP_IF0:  "JMP_IF0",
+	vm.CMD_JMP_NEG:  "JMP_NEG",
+	vm.CMD_RET:      "RET",
+	vm.CMD_FINISH:   "FINISH",
+	vm.CMD_PUTCHAR:  "PUTCHAR",
+	vm.CMD_PUTNUM:   "PUTNUM",
+	vm.CMD_READCHAR: "READCHAR",
+	vm.CMD_READNUM:  "READNUM",
+}
+
+var opcodes = func() map[string]int {
+	m := make(map[string]int, len(mnemonics))
+	for op, name := range mnemonics {
+		m[name] = op
+	}
+	return m
+}()
+
+// imps mirrors the Imp category package parser assigns each opcode, so a
+// vm.Command built by Assemble looks the same as one parser.Parse would
+// have produced.
+var imps = map[int]int{
+	vm.CMD_PUSH: vm.IMP_STACK, vm.CMD_DUP: vm.IMP_STACK, vm.CMD_COPY: vm.IMP_STACK,
+	vm.CMD_SWAP: vm.IMP_STACK, vm.CMD_DISCARD: vm.IMP_STACK, vm.CMD_SLIDE: vm.IMP_STACK,
+	vm.CMD_ADD: vm.IMP_ARITH, vm.CMD_SUB: vm.IMP_ARITH, vm.CMD_MUL: vm.IMP_ARITH,
+	vm.CMD_DIV: vm.IMP_ARITH, vm.CMD_MOD: vm.IMP_ARITH,
+	vm.CMD_STORE: vm.IMP_HEAP, vm.CMD_RETRIEVE: vm.IMP_HEAP,
+	vm.CMD_MARK: vm.IMP_FLOW, vm.CMD_CALL: vm.IMP_FLOW, vm.CMD_JMP: vm.IMP_FLOW,
+	vm.CMD_JMP_IF0: vm.IMP_FLOW, vm.CMD_JMP_NEG: vm.IMP_FLOW, vm.CMD_RET: vm.IMP_FLOW,
+	vm.CMD_FINISH:  vm.IMP_FLOW,
+	vm.CMD_PUTCHAR: vm.IMP_IO, vm.CMD_PUTNUM: vm.IMP_IO, vm.CMD_READCHAR: vm.IMP_IO, vm.CMD_READNUM: vm.IMP_IO,
+}
+
+// Assemble reads the mnemonic text format (one instruction per line,
+// ";" comments, labels written "L<id>") and lowers it to a vm.Program, the
+// same representation parser.Parse produces from real Whitespace source.
+func Assemble(r io.Reader) (*vm.Program, error) {
+	program := &vm.Program{Labels: make(map[int]int)}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToUpper(fields[0])
+		op, ok := opcodes[name]
+		if !ok {
+			return nil, fmt.Errorf("asm: line %d: unknown mnemonic %q", lineNo, fields[0])
+		}
+		cmd, err := assembleCommand(op, name, fields[1:], lineNo)
+		if err != nil {
+			return nil, err
+		}
+		program.Commands = append(program.Commands, cmd)
+		if op == vm.CMD_MARK {
+			program.Labels[cmd.Val] = len(program.Commands) - 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+func assembleCommand(op int, name string, args []string, lineNo int) (vm.Command, error) {
+	cmd := vm.Command{Imp: imps[op], Cmd: op, Val: -1}
+	switch op {
+	case vm.CMD_PUSH:
+		if len(args) != 1 {
+			return cmd, fmt.Errorf("asm: line %d: PUSH needs exactly one operand", lineNo)
+		}
+		n, ok := new(big.Int).SetString(args[0], 10)
+		if !ok {
+			return cmd, fmt.Errorf("asm: line %d: bad integer %q", lineNo, args[0])
+		}
+		cmd.Val = 0
+		cmd.Num = vm.NewNumber(false, n)
+		cmd.Cmdstr = fmt.Sprintf("PUSH %s", cmd.Num)
+	case vm.CMD_COPY, vm.CMD_SLIDE:
+		v, err := expectInt(args, lineNo, name)
+		if err != nil {
+			return cmd, err
+		}
+		cmd.Val = v
+		cmd.Cmdstr = fmt.Sprintf("%s %d", name, v)
+	case vm.CMD_MARK, vm.CMD_CALL, vm.CMD_JMP, vm.CMD_JMP_IF0, vm.CMD_JMP_NEG:
+		label, err := expectLabel(args, lineNo, name)
+		if err != nil {
+			return cmd, err
+		}
+		cmd.Val = label
+		cmd.Cmdstr = fmt.Sprintf("%s L%d", name, label)
+	default:
+		if len(args) != 0 {
+			return cmd, fmt.Errorf("asm: line %d: %s takes no operand", lineNo, name)
+		}
+		cmd.Cmdstr = name
+	}
+	return cmd, nil
+}
+
+func expectInt(args []string, lineNo int, name string) (int, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("asm: line %d: %s needs exactly one operand", lineNo, name)
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("asm: line %d: bad integer %q", lineNo, args[0])
+	}
+	return n, nil
+}
+
+func expectLabel(args []string, lineNo int, name string) (int, error) {
+	if len(args) != 1 || !strings.HasPrefix(args[0], "L") {
+		return 0, fmt.Errorf("asm: line %d: %s needs a label operand like L1", lineNo, name)
+	}
+	n, err := strconv.Atoi(args[0][1:])
+	if err != nil {
+		return 0, fmt.Errorf("asm: line %d: bad label %q", lineNo, args[0])
+	}
+	return n, nil
+}
+
+// Disassemble renders program back to the text Assemble reads, one
+// instruction per line.
+func Disassemble(program *vm.Program, w io.Writer) error {
+	for _, cmd := range program.Commands {
+		var line string
+		switch cmd.Cmd {
+		case vm.CMD_PUSH:
+			line = fmt.Sprintf("PUSH %s", cmd.Num)
+		case vm.CMD_COPY, vm.CMD_SLIDE:
+			line = fmt.Sprintf("%s %d", mnemonics[cmd.Cmd], cmd.Val)
+		case vm.CMD_MARK, vm.CMD_CALL, vm.CMD_JMP, vm.CMD_JMP_IF0, vm.CMD_JMP_NEG:
+			line = fmt.Sprintf("%s L%d", mnemonics[cmd.Cmd], cmd.Val)
+		default:
+			line = mnemonics[cmd.Cmd]
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Emit writes program out as real Whitespace source (space/tab/newline
+// bytes), the inverse of what package parser reads, so a program can be
+// hand-edited in mnemonic form and fed back to the ordinary interpreter.
+func Emit(program *vm.Program, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, cmd := range program.Commands {
+		emitCommand(bw, cmd)
+	}
+	return bw.Flush()
+}
+
+func emitCommand(w *bufio.Writer, cmd vm.Command) {
+	switch cmd.Imp {
+	case vm.IMP_STACK:
+		w.WriteByte(' ')
+	case vm.IMP_ARITH:
+		w.WriteString("\t ")
+	case vm.IMP_HEAP:
+		w.WriteString("\t\t")
+	case vm.IMP_FLOW:
+		w.WriteByte('\n')
+	case vm.IMP_IO:
+		w.WriteString("\t\n")
+	}
+	switch cmd.Cmd {
+	case vm.CMD_PUSH:
+		w.WriteByte(' ')
+		emitValue(w, cmd.Num)
+	case vm.CMD_DUP:
+		w.WriteString("\n ")
+	case vm.CMD_SWAP:
+		w.WriteString("\n\t")
+	case vm.CMD_DISCARD:
+		w.WriteString("\n\n")
+	case vm.CMD_COPY:
+		w.WriteString("\t ")
+		emitNumber(w, cmd.Val)
+	case vm.CMD_SLIDE:
+		w.WriteString("\t\n")
+		emitNumber(w, cmd.Val)
+	case vm.CMD_ADD:
+		w.WriteString("  ")
+	case vm.CMD_SUB:
+		w.WriteString(" \t")
+	case vm.CMD_MUL:
+		w.WriteString(" \n")
+	case vm.CMD_DIV:
+		w.WriteString("\t ")
+	case vm.CMD_MOD:
+		w.WriteString("\t\t")
+	case vm.CMD_STORE:
+		w.WriteByte(' ')
+	case vm.CMD_RETRIEVE:
+		w.WriteByte('\t')
+	case vm.CMD_MARK:
+		w.WriteString("  ")
+		emitNumber(w, cmd.Val)
+	case vm.CMD_CALL:
+		w.WriteString(" \t")
+		emitNumber(w, cmd.Val)
+	case vm.CMD_JMP:
+		w.WriteString(" \n")
+		emitNumber(w, cmd.Val)
+	case vm.CMD_JMP_IF0:
+		w.WriteString("\t ")
+		emitNumber(w, cmd.Val)
+	case vm.CMD_JMP_NEG:
+		w.WriteString("\t\t")
+		emitNumber(w, cmd.Val)
+	case vm.CMD_RET:
+		w.WriteString("\t\n")
+	case vm.CMD_FINISH:
+		w.WriteString("\n\n")
+	case vm.CMD_PUTCHAR:
+		w.WriteString("  ")
+	case vm.CMD_PUTNUM:
+		w.WriteString(" \t")
+	case vm.CMD_READCHAR:
+		w.WriteString("\t ")
+	case vm.CMD_READNUM:
+		w.WriteString("\t\t")
+	}
+}
+
+// emitNumber writes a plain machine-word value (a label id or COPY/SLIDE
+// count) in the sign-then-bits-then-newline form parseNumber reads.
+func emitNumber(w *bufio.Writer, n int) {
+	sign := byte(' ')
+	abs := int64(n)
+	if n < 0 {
+		sign = '\t'
+		abs = -abs
+	}
+	w.WriteByte(sign)
+	writeBits(w, big.NewInt(abs))
+	w.WriteByte('\n')
+}
+
+// emitValue writes an arbitrary-precision PUSH literal the same way, using
+// Number's Sign/Bytes instead of assuming it fits a machine word.
+func emitValue(w *bufio.Writer, num vm.Number) {
+	sign := byte(' ')
+	if num.Sign() < 0 {
+		sign = '\t'
+	}
+	w.WriteByte(sign)
+	writeBits(w, new(big.Int).SetBytes(num.Bytes()))
+	w.WriteByte('\n')
+}
+
+func writeBits(w *bufio.Writer, v *big.Int) {
+	if v.Sign() == 0 {
+		return
+	}
+	for _, c := range v.Text(2) {
+		if c == '1' {
+			w.WriteByte('\t')
+		} else {
+			w.WriteByte(' ')
+		}
+	}
+}
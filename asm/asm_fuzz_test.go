@@ -0,0 +1,107 @@
+package asm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kinu/whitespace/parser"
+	"github.com/kinu/whitespace/vm"
+)
+
+// fataler is the subset of testing.T/testing.F that mustEmit needs; *testing.F
+// doesn't implement the full testing.TB interface, so this is narrowed to
+// what both actually share.
+type fataler interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+}
+
+// mustEmit assembles mnemonic source and emits it as real Whitespace bytes,
+// for building readable fuzz seeds instead of hand-typed space/tab literals.
+func mustEmit(t fataler, mnemonic string) []byte {
+	t.Helper()
+	program, err := Assemble(bytes.NewReader([]byte(mnemonic)))
+	if err != nil {
+		t.Fatalf("Assemble(%q): %v", mnemonic, err)
+	}
+	var buf bytes.Buffer
+	if err := Emit(program, &buf); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// FuzzRoundTrip checks that Whitespace -> Disassemble -> Assemble, and
+// Whitespace -> Disassemble -> Assemble -> Emit -> Whitespace, both preserve
+// a program's semantics: the same sequence of commands, not just something
+// that happens to look similar.
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(mustEmit(f, "PUSH 72\nPUTCHAR\nFINISH\n"))
+	f.Add(mustEmit(f, "PUSH 3\nPUSH 4\nADD\nPUTNUM\nFINISH\n"))
+	f.Add(mustEmit(f, `; a loop counting down from 5 to 1
+PUSH 5
+MARK L0
+DUP
+JMP_IF0 L1
+DUP
+PUTNUM
+PUSH 1
+SUB
+JMP L0
+MARK L1
+DISCARD
+FINISH
+`))
+	f.Add(mustEmit(f, "PUSH -9223372036854775808\nPUSH -1\nMUL\nPUTNUM\nFINISH\n"))
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		program, err := parser.Parse(bytes.NewReader(src))
+		if err != nil {
+			t.Skip()
+		}
+
+		var mnemonic bytes.Buffer
+		if err := Disassemble(program, &mnemonic); err != nil {
+			t.Fatalf("Disassemble: %v", err)
+		}
+
+		reassembled, err := Assemble(bytes.NewReader(mnemonic.Bytes()))
+		if err != nil {
+			t.Fatalf("Assemble round-tripped mnemonic: %v\n%s", err, mnemonic.String())
+		}
+		if !programsEqual(program, reassembled) {
+			t.Fatalf("disasm+asm changed program semantics\nmnemonic:\n%s", mnemonic.String())
+		}
+
+		var rewritten bytes.Buffer
+		if err := Emit(reassembled, &rewritten); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+		reparsed, err := parser.Parse(bytes.NewReader(rewritten.Bytes()))
+		if err != nil {
+			t.Fatalf("re-parsing emitted Whitespace: %v", err)
+		}
+		if !programsEqual(program, reparsed) {
+			t.Fatalf("Emit produced Whitespace with different semantics")
+		}
+	})
+}
+
+func programsEqual(a, b *vm.Program) bool {
+	if len(a.Commands) != len(b.Commands) || len(a.Labels) != len(b.Labels) {
+		return false
+	}
+	for i := range a.Commands {
+		ca, cb := a.Commands[i], b.Commands[i]
+		if ca.Imp != cb.Imp || ca.Cmd != cb.Cmd || ca.Val != cb.Val {
+			return false
+		}
+		switch {
+		case ca.Num == nil && cb.Num == nil:
+		case ca.Num != nil && cb.Num != nil && ca.Num.Cmp(cb.Num) == 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+// Command whitesc compiles a Whitespace source file to a standalone
+// WebAssembly module, exported as "run" over a "memory" export, so it can
+// be loaded by a JS shim instead of the Go interpreter. The shim supplies
+// the env.putchar/putnum/readchar/readnum imports wasm.Compile expects.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kinu/whitespace/parser"
+	"github.com/kinu/whitespace/wasm"
+)
+
+func main() {
+	out := flag.String("o", "", "output .wasm file (stdout if empty)")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: whitesc [-o outfile.wasm] inputfile")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	program, err := parser.Parse(f)
+	if err != nil {
+		panic(err)
+	}
+
+	module, err := wasm.Compile(*program)
+	if err != nil {
+		panic(err)
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		w, err = os.Create(*out)
+		if err != nil {
+			panic(err)
+		}
+		defer w.Close()
+	}
+	if _, err := w.Write(module); err != nil {
+		panic(err)
+	}
+}
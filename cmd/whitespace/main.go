@@ -0,0 +1,175 @@
+// Command whitespace is a thin CLI over the parser and vm packages.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kinu/whitespace/asm"
+	wsio "github.com/kinu/whitespace/io"
+	"github.com/kinu/whitespace/parser"
+	"github.com/kinu/whitespace/vm"
+)
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "asm":
+			runAsm(os.Args[2:])
+			return
+		case "disasm":
+			runDisasm(os.Args[2:])
+			return
+		}
+	}
+
+	verbose := flag.Bool("v", false, "trace parsing (each command as it's read); use -debug to inspect execution")
+	dryRun := flag.Bool("dry_run", false, "dry run")
+	bench := flag.Bool("bench", false, "compile and run, reporting instructions/sec")
+	bignum := flag.Bool("bignum", false, "force every value through a math/big.Int backend")
+	debug := flag.Bool("debug", false, "run under an interactive REPL debugger instead of straight through")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 {
+		panic("Usage: whitespace [-v][-dry_run][-bench][-bignum][-debug] inputfile")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	if *verbose {
+		fmt.Printf("\n* Parsing the program:\n\n")
+	}
+
+	program, err := parser.ParseWithOptions(f, *verbose, *bignum)
+	if err != nil {
+		panic(err)
+	}
+
+	if *verbose {
+		fmt.Printf("\n\n* Running the program:\n\n")
+	}
+
+	cp := program.Compile()
+	streams := wsio.Stdio()
+	m := vm.NewMachine(*bignum, streams.In, streams.Out)
+
+	if *bench {
+		start := time.Now()
+		insns := m.RunCompiled(cp)
+		elapsed := time.Since(start)
+		fmt.Printf("\n%d instructions in %v (%.0f instructions/sec)\n",
+			insns, elapsed, float64(insns)/elapsed.Seconds())
+		return
+	}
+
+	if *debug {
+		breakpointsPath, historyPath := ".whitespace_breakpoints", ".whitespace_history"
+		if dir, err := debuggerStateDir(args[0]); err == nil {
+			breakpointsPath, historyPath = filepath.Join(dir, "breakpoints"), filepath.Join(dir, "history")
+		}
+		d := vm.NewDebugger(&m, program, cp, os.Stdin, os.Stdout, breakpointsPath, historyPath)
+		if err := d.Run(); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if !*dryRun {
+		if _, err := m.Run(context.Background(), cp); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// debuggerStateDir returns the directory the debugger should persist
+// breakpoints/history for inputPath under: a subdirectory of the user's
+// config directory keyed by inputPath's absolute form, rather than whatever
+// directory whitespace happens to be run from. Keying by program rather
+// than using one shared file means two sessions debugging different
+// programs don't stomp each other's breakpoints and history; two sessions
+// debugging the same program sharing state is the same thing a single
+// cwd-relative file would have done before. Returns an error (callers
+// should fall back to the old cwd-relative ".whitespace_<kind>" names) if
+// the config directory can't be determined or created.
+func debuggerStateDir(inputPath string) (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(inputPath)
+	if err != nil {
+		abs = inputPath
+	}
+	sum := sha256.Sum256([]byte(abs))
+	dir := filepath.Join(configDir, "whitespace", hex.EncodeToString(sum[:8]))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// runAsm assembles the mnemonic text format into real Whitespace source.
+func runAsm(args []string) {
+	fs := flag.NewFlagSet("asm", flag.ExitOnError)
+	out := fs.String("o", "", "output file (stdout if empty)")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		panic("Usage: whitespace asm [-o outfile] inputfile")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	program, err := asm.Assemble(f)
+	if err != nil {
+		panic(err)
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		w, err = os.Create(*out)
+		if err != nil {
+			panic(err)
+		}
+		defer w.Close()
+	}
+	if err := asm.Emit(program, w); err != nil {
+		panic(err)
+	}
+}
+
+// runDisasm renders a Whitespace source file as the mnemonic text format.
+func runDisasm(args []string) {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		panic("Usage: whitespace disasm inputfile")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	program, err := parser.Parse(f)
+	if err != nil {
+		panic(err)
+	}
+	if err := asm.Disassemble(program, os.Stdout); err != nil {
+		panic(err)
+	}
+}
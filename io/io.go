@@ -0,0 +1,22 @@
+// Package io bundles the stdio streams vm.Machine reads/writes by default,
+// so embedders can swap them for something else (a test buffer, a web
+// playground's request/response) by constructing vm.Machine directly with
+// their own io.Reader/io.Writer instead of calling Stdio.
+package io
+
+import (
+	"io"
+	"os"
+)
+
+// Streams is the pair of streams READCHAR/READNUM and PUTCHAR/PUTNUM talk
+// to.
+type Streams struct {
+	In  io.Reader
+	Out io.Writer
+}
+
+// Stdio returns the streams the standalone CLI uses: stdin and stdout.
+func Stdio() Streams {
+	return Streams{In: os.Stdin, Out: os.Stdout}
+}
@@ -0,0 +1,312 @@
+// Package parser turns Whitespace source into a vm.Program. Parse and
+// ParseWithOptions return an error instead of panicking, so callers (test
+// harnesses, web playgrounds) can embed the interpreter without recovering
+// from arbitrary panics themselves.
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/kinu/whitespace/vm"
+)
+
+type parser struct {
+	program  vm.Program
+	r        *bytes.Reader
+	size     int
+	cmdStart int
+	err      error
+	verbose  bool
+	bignum   bool
+	finished bool
+}
+
+func newParser(data []byte, verbose bool, bignum bool) *parser {
+	p := &parser{r: bytes.NewReader(data), size: len(data), verbose: verbose, bignum: bignum}
+	p.program.Commands = make([]vm.Command, 0, 100)
+	p.program.Labels = make(map[int]int)
+	return p
+}
+
+// offset is the byte position of the next unread source byte, used to tag
+// each Command with where it started so a debugger can point back at the
+// offending whitespace span.
+func (p *parser) offset() int {
+	return p.size - p.r.Len()
+}
+
+func (p *parser) writeCommand(imp int, cmd int, val int, num vm.Number, str string, a ...interface{}) {
+	s := fmt.Sprintf(str, a...)
+	p.program.Commands = append(p.program.Commands, vm.Command{Imp: imp, Cmd: cmd, Val: val, Num: num, Cmdstr: s, Offset: p.cmdStart})
+	if cmd == vm.CMD_MARK {
+		p.program.Labels[val] = len(p.program.Commands) - 1
+	}
+	if p.verbose {
+		fmt.Println(s)
+	}
+}
+
+func (p *parser) readSpace() (c byte) {
+	if p.err != nil {
+		return
+	}
+	for {
+		c, p.err = p.r.ReadByte()
+		if p.err == io.EOF || c == '\t' || c == ' ' || c == '\n' {
+			return
+		}
+	}
+}
+
+// parseNumber reads a label or COPY/SLIDE count: these never need more
+// than machine-word range, so they stay a plain int. See parseValue for the
+// arbitrary-precision literals PUSH operates on.
+func (p *parser) parseNumber() (n int) {
+	n = 0
+	c := p.readSpace()
+	sign := 1
+	if c == '\t' {
+		sign = -1
+	}
+	for {
+		c = p.readSpace()
+		if p.err != nil {
+			panic(fmt.Errorf("unexpected EOF while parsing number"))
+		}
+		switch c {
+		case ' ':
+			n = n<<1 + 0
+		case '\t':
+			n = n<<1 + 1
+		case '\n':
+			return n * sign
+		}
+	}
+}
+
+// parseValue reads a PUSH literal the same way parseNumber reads a label,
+// but accumulates into a big.Int so a literal beyond 63 bits (legal, since
+// Whitespace integers are unbounded) doesn't silently truncate, then hands
+// the result to vm.NewNumber to pick the cheapest representation.
+func (p *parser) parseValue() vm.Number {
+	c := p.readSpace()
+	sign := 1
+	if c == '\t' {
+		sign = -1
+	}
+	n := new(big.Int)
+	for {
+		c = p.readSpace()
+		if p.err != nil {
+			panic(fmt.Errorf("unexpected EOF while parsing number"))
+		}
+		switch c {
+		case ' ':
+			n.Lsh(n, 1)
+		case '\t':
+			n.Lsh(n, 1)
+			n.Or(n, big.NewInt(1))
+		case '\n':
+			if sign < 0 {
+				n.Neg(n)
+			}
+			return vm.NewNumber(p.bignum, n)
+		}
+	}
+}
+
+func (p *parser) parseImp() (imp int) {
+	c := p.readSpace()
+	switch c {
+	case ' ':
+		return vm.IMP_STACK
+	case '\n':
+		return vm.IMP_FLOW
+	case '\t':
+		c = p.readSpace()
+		switch c {
+		case ' ':
+			return vm.IMP_ARITH
+		case '\t':
+			return vm.IMP_HEAP
+		case '\n':
+			return vm.IMP_IO
+		}
+	}
+	return vm.IMP_NONE
+}
+
+func (p *parser) parseStackCommand() {
+	c := p.readSpace()
+	switch c {
+	case ' ':
+		n := p.parseValue()
+		p.writeCommand(vm.IMP_STACK, vm.CMD_PUSH, 0, n, "PUSH %s", n)
+	case '\n':
+		c = p.readSpace()
+		switch c {
+		case ' ':
+			p.writeCommand(vm.IMP_STACK, vm.CMD_DUP, -1, nil, "DUP")
+		case '\t':
+			p.writeCommand(vm.IMP_STACK, vm.CMD_SWAP, -1, nil, "SWAP")
+		case '\n':
+			p.writeCommand(vm.IMP_STACK, vm.CMD_DISCARD, -1, nil, "DISCARD")
+		}
+	case '\t':
+		c = p.readSpace()
+		n := p.parseNumber()
+		switch c {
+		case ' ':
+			p.writeCommand(vm.IMP_STACK, vm.CMD_COPY, n, nil, "COPY %d", n)
+		case '\n':
+			p.writeCommand(vm.IMP_STACK, vm.CMD_SLIDE, n, nil, "SLIDE %d", n)
+		}
+	}
+}
+
+func (p *parser) parseArithCommand() {
+	c := p.readSpace()
+	switch c {
+	case ' ':
+		c = p.readSpace()
+		switch c {
+		case ' ':
+			p.writeCommand(vm.IMP_ARITH, vm.CMD_ADD, -1, nil, "ADD")
+		case '\t':
+			p.writeCommand(vm.IMP_ARITH, vm.CMD_SUB, -1, nil, "SUB")
+		case '\n':
+			p.writeCommand(vm.IMP_ARITH, vm.CMD_MUL, -1, nil, "MUL")
+		}
+	case '\t':
+		c = p.readSpace()
+		switch c {
+		case ' ':
+			p.writeCommand(vm.IMP_ARITH, vm.CMD_DIV, -1, nil, "DIV")
+		case '\t':
+			p.writeCommand(vm.IMP_ARITH, vm.CMD_MOD, -1, nil, "MOD")
+		}
+	}
+}
+
+func (p *parser) parseHeapCommand() {
+	c := p.readSpace()
+	switch c {
+	case ' ':
+		p.writeCommand(vm.IMP_HEAP, vm.CMD_STORE, -1, nil, "STORE")
+	case '\t':
+		p.writeCommand(vm.IMP_HEAP, vm.CMD_RETRIEVE, -1, nil, "RETRIEVE")
+	}
+}
+
+func (p *parser) parseFlowCommand() {
+	c := p.readSpace()
+	switch c {
+	case ' ':
+		c = p.readSpace()
+		label := p.parseNumber()
+		switch c {
+		case ' ':
+			p.writeCommand(vm.IMP_FLOW, vm.CMD_MARK, label, nil, "MARK %d", label)
+		case '\t':
+			p.writeCommand(vm.IMP_FLOW, vm.CMD_CALL, label, nil, "CALL %d", label)
+		case '\n':
+			p.writeCommand(vm.IMP_FLOW, vm.CMD_JMP, label, nil, "JMP %d", label)
+		}
+	case '\t':
+		c = p.readSpace()
+		switch c {
+		case ' ':
+			label := p.parseNumber()
+			p.writeCommand(vm.IMP_FLOW, vm.CMD_JMP_IF0, label, nil, "JMP_IF0 %d", label)
+		case '\t':
+			label := p.parseNumber()
+			p.writeCommand(vm.IMP_FLOW, vm.CMD_JMP_NEG, label, nil, "JMP_NEG %d", label)
+		case '\n':
+			p.writeCommand(vm.IMP_FLOW, vm.CMD_RET, -1, nil, "RET")
+		}
+	case '\n':
+		c = p.readSpace()
+		if c == '\n' {
+			p.writeCommand(vm.IMP_FLOW, vm.CMD_FINISH, -1, nil, "FINISH")
+		}
+	}
+}
+
+func (p *parser) parseIOCommand() {
+	c := p.readSpace()
+	switch c {
+	case ' ':
+		c = p.readSpace()
+		switch c {
+		case ' ':
+			p.writeCommand(vm.IMP_IO, vm.CMD_PUTCHAR, -1, nil, "PUTCHAR")
+		case '\t':
+			p.writeCommand(vm.IMP_IO, vm.CMD_PUTNUM, -1, nil, "PUTNUM")
+		}
+	case '\t':
+		c = p.readSpace()
+		switch c {
+		case ' ':
+			p.writeCommand(vm.IMP_IO, vm.CMD_READCHAR, -1, nil, "READCHAR")
+		case '\t':
+			p.writeCommand(vm.IMP_IO, vm.CMD_READNUM, -1, nil, "READNUM")
+		}
+	}
+}
+
+func (p *parser) parse() {
+	for {
+		p.cmdStart = p.offset()
+		imp := p.parseImp()
+		if p.err == io.EOF {
+			return
+		}
+		if p.err != nil {
+			panic(p.err)
+		}
+		switch imp {
+		case vm.IMP_NONE:
+			panic("Parse error")
+		case vm.IMP_STACK:
+			p.parseStackCommand()
+		case vm.IMP_ARITH:
+			p.parseArithCommand()
+		case vm.IMP_HEAP:
+			p.parseHeapCommand()
+		case vm.IMP_FLOW:
+			p.parseFlowCommand()
+		case vm.IMP_IO:
+			p.parseIOCommand()
+		}
+	}
+}
+
+// Parse reads Whitespace source from r and lowers it to a vm.Program.
+func Parse(r io.Reader) (*vm.Program, error) {
+	return ParseWithOptions(r, false, false)
+}
+
+// ParseWithOptions is Parse with the -v/-bignum knobs the CLI exposes:
+// verbose echoes each command as it's parsed, and bignum forces every PUSH
+// literal through the math/big.Int backend regardless of size.
+func ParseWithOptions(r io.Reader, verbose bool, bignum bool) (prog *vm.Program, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := newParser(data, verbose, bignum)
+	defer func() {
+		if rec := recover(); rec != nil {
+			if e, ok := rec.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", rec)
+			}
+		}
+	}()
+	p.parse()
+	return &p.program, nil
+}
@@ -0,0 +1,39 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestParseTruncatedNumberReturnsError covers sources that hit EOF in the
+// middle of a label or PUSH literal: readSpace returning io.EOF used to
+// leave parseNumber/parseValue's inner loop spinning forever instead of
+// noticing p.err, since a zero byte matches none of their space/tab/newline
+// cases. Parse must return an error instead of hanging.
+func TestParseTruncatedNumberReturnsError(t *testing.T) {
+	cases := map[string][]byte{
+		"push truncated after sign and one bit": {' ', '\t', ' '},
+		"push truncated after sign only":        {' ', ' ', ' '},
+		"label truncated after sign":            {'\n', ' ', ' '},
+	}
+	for name, src := range cases {
+		src := src
+		t.Run(name, func(t *testing.T) {
+			done := make(chan struct{})
+			var err error
+			go func() {
+				_, err = Parse(bytes.NewReader(src))
+				close(done)
+			}()
+			select {
+			case <-done:
+				if err == nil {
+					t.Fatalf("Parse(%v): expected an error, got nil", src)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Parse(%v): did not return, likely hung", src)
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Debugger drives a CompiledProgram one instruction at a time from an
+// interactive REPL built on Machine's Step, adding breakpoints, watchpoints
+// and stack/heap/call-frame inspection. It's what the CLI's -debug flag
+// wires Machine up to, and the way to inspect execution now that Machine
+// itself has no verbose tracing hook.
+type Debugger struct {
+	m               *Machine
+	program         *Program
+	cp              CompiledProgram
+	pc              int
+	breaks          map[int]bool
+	watches         map[int]Number
+	history         []string
+	breakpointsPath string
+	historyPath     string
+	in              *bufio.Scanner
+	out             io.Writer
+}
+
+// NewDebugger builds a Debugger over an already-compiled program, reading
+// REPL commands from in and writing output/prompts to out. program is the
+// pre-compile form, kept around so breakpoints and disasm can report source
+// byte offsets via Command.Offset.
+//
+// breakpointsPath and historyPath are where breakpoints and command history
+// persist across sessions, mirroring how interactive REPLs (e.g. gdb, the Go
+// repl) keep a .gdb_history-style file next to where they're run; pass "" for
+// either to keep it in memory for this session only, which embedders (e.g. a
+// web playground) that don't want filesystem side effects should do.
+func NewDebugger(m *Machine, program *Program, cp CompiledProgram, in io.Reader, out io.Writer, breakpointsPath, historyPath string) *Debugger {
+	d := &Debugger{
+		m:               m,
+		program:         program,
+		cp:              cp,
+		breaks:          make(map[int]bool),
+		watches:         make(map[int]Number),
+		breakpointsPath: breakpointsPath,
+		historyPath:     historyPath,
+		in:              bufio.NewScanner(in),
+		out:             out,
+	}
+	d.loadBreakpoints()
+	d.loadHistory()
+	return d
+}
+
+// Run starts the REPL loop and returns once the user quits (or stdin is
+// closed). It never returns an error itself; a bad command just prints a
+// usage message and the loop continues.
+func (d *Debugger) Run() error {
+	fmt.Fprintln(d.out, "whitespace debugger; type 'help' for commands")
+	for {
+		fmt.Fprint(d.out, "(ws) ")
+		if !d.in.Scan() {
+			return nil
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			continue
+		}
+		d.appendHistory(line)
+		if d.execute(line) {
+			return nil
+		}
+	}
+}
+
+func (d *Debugger) execute(line string) (quit bool) {
+	fields := strings.Fields(line)
+	cmd, args := fields[0], fields[1:]
+	switch cmd {
+	case "break", "b":
+		d.cmdBreak(args)
+	case "delete":
+		d.cmdDelete(args)
+	case "step", "s":
+		d.cmdStep()
+	case "next", "n":
+		d.cmdNext()
+	case "continue", "c":
+		d.cmdContinue()
+	case "bt":
+		d.cmdBacktrace()
+	case "stack":
+		fmt.Fprintln(d.out, d.m.stack)
+	case "heap":
+		d.cmdHeap(args)
+	case "disasm":
+		d.cmdDisasm(args)
+	case "watch":
+		d.cmdWatch(args)
+	case "history":
+		for _, h := range d.history {
+			fmt.Fprintln(d.out, h)
+		}
+	case "help":
+		d.printHelp()
+	case "quit", "exit", "q":
+		return true
+	default:
+		fmt.Fprintf(d.out, "unknown command %q; type 'help'\n", cmd)
+	}
+	return false
+}
+
+// resolve turns a "break"/"delete" argument into a compiled pc: either a
+// plain instruction index, or "L<label>" which resolves to the pc of the
+// first surviving instruction at or after the label's MARK.
+func (d *Debugger) resolve(s string) (int, bool) {
+	if strings.HasPrefix(s, "L") {
+		label, err := strconv.Atoi(s[1:])
+		if err != nil {
+			return 0, false
+		}
+		cmdIdx, ok := d.program.Labels[label]
+		if !ok {
+			return 0, false
+		}
+		for pc, srcIdx := range d.cp.src {
+			if srcIdx >= cmdIdx {
+				return pc, true
+			}
+		}
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n >= d.cp.Len() {
+		return 0, false
+	}
+	return n, true
+}
+
+func (d *Debugger) cmdBreak(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "usage: break <pc>|L<label>")
+		return
+	}
+	pc, ok := d.resolve(args[0])
+	if !ok {
+		fmt.Fprintf(d.out, "cannot resolve %q\n", args[0])
+		return
+	}
+	d.breaks[pc] = true
+	d.saveBreakpoints()
+	fmt.Fprintf(d.out, "breakpoint at pc %d\n", pc)
+}
+
+func (d *Debugger) cmdDelete(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "usage: delete <pc>")
+		return
+	}
+	pc, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Fprintln(d.out, "bad pc")
+		return
+	}
+	delete(d.breaks, pc)
+	d.saveBreakpoints()
+}
+
+func (d *Debugger) cmdStep() {
+	if d.pc < 0 || d.pc >= d.cp.Len() {
+		fmt.Fprintln(d.out, "program finished")
+		return
+	}
+	d.pc = d.m.Step(d.cp, d.pc)
+	d.checkWatches()
+	d.report()
+}
+
+// cmdNext steps, but if the current instruction is a CALL, runs until the
+// frame stack unwinds back to its pre-call depth instead of stopping inside
+// the callee — the usual "step over" semantics.
+func (d *Debugger) cmdNext() {
+	if d.pc < 0 || d.pc >= d.cp.Len() {
+		fmt.Fprintln(d.out, "program finished")
+		return
+	}
+	isCall := d.cp.op[d.pc] == CMD_CALL
+	depth := d.m.frame.Len()
+	d.pc = d.m.Step(d.cp, d.pc)
+	if isCall {
+		for d.pc >= 0 && d.pc < d.cp.Len() && d.m.frame.Len() > depth {
+			if d.checkWatches() || d.breaks[d.pc] {
+				break
+			}
+			d.pc = d.m.Step(d.cp, d.pc)
+		}
+	}
+	d.checkWatches()
+	d.report()
+}
+
+func (d *Debugger) cmdContinue() {
+	for d.pc >= 0 && d.pc < d.cp.Len() {
+		d.pc = d.m.Step(d.cp, d.pc)
+		if d.checkWatches() {
+			d.report()
+			return
+		}
+		if d.breaks[d.pc] {
+			fmt.Fprintf(d.out, "breakpoint hit at pc %d\n", d.pc)
+			d.report()
+			return
+		}
+	}
+	fmt.Fprintln(d.out, "program finished")
+}
+
+// checkWatches compares every watched heap cell against its last known
+// value, reporting (and stopping on) any that changed.
+func (d *Debugger) checkWatches() bool {
+	changed := false
+	for addr, old := range d.watches {
+		if addr >= d.m.heap.Len() {
+			continue
+		}
+		cur := d.m.heap.Get(addr)
+		if old == nil || cur.Cmp(old) != 0 {
+			fmt.Fprintf(d.out, "watch: heap[%d] changed to %s\n", addr, cur)
+			d.watches[addr] = cur
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (d *Debugger) cmdWatch(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "usage: watch <addr>")
+		return
+	}
+	addr, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Fprintln(d.out, "bad address")
+		return
+	}
+	var cur Number
+	if addr < d.m.heap.Len() {
+		cur = d.m.heap.Get(addr)
+	}
+	d.watches[addr] = cur
+	fmt.Fprintf(d.out, "watching heap[%d]\n", addr)
+}
+
+func (d *Debugger) cmdHeap(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(d.out, "usage: heap <addr> [len]")
+		return
+	}
+	addr, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Fprintln(d.out, "bad address")
+		return
+	}
+	n := 1
+	if len(args) > 1 {
+		if v, err := strconv.Atoi(args[1]); err == nil {
+			n = v
+		}
+	}
+	for i := 0; i < n; i++ {
+		addr := addr + i
+		if addr >= d.m.heap.Len() {
+			break
+		}
+		fmt.Fprintf(d.out, "heap[%d] = %s\n", addr, d.m.heap.Get(addr))
+	}
+}
+
+func (d *Debugger) cmdBacktrace() {
+	for i := d.m.frame.Len() - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, "#%d return pc=%d\n", d.m.frame.Len()-1-i, d.m.frame[i])
+	}
+}
+
+func (d *Debugger) cmdDisasm(args []string) {
+	n := 10
+	if len(args) > 0 {
+		if v, err := strconv.Atoi(args[0]); err == nil {
+			n = v
+		}
+	}
+	for i := 0; i < n && d.pc+i < d.cp.Len(); i++ {
+		pc := d.pc + i
+		marker := "  "
+		switch {
+		case pc == d.pc:
+			marker = "->"
+		case d.breaks[pc]:
+			marker = "* "
+		}
+		fmt.Fprintf(d.out, "%s %4d  op=%d arg=%d  (src byte %d)\n", marker, pc, d.cp.op[pc], d.cp.arg[pc], d.sourceOffset(pc))
+	}
+}
+
+// sourceOffset returns the byte position in the original Whitespace source
+// that compiled instruction pc was lowered from, or -1 if it can't be found.
+func (d *Debugger) sourceOffset(pc int) int {
+	if pc < 0 || pc >= len(d.cp.src) {
+		return -1
+	}
+	src := d.cp.src[pc]
+	if src < 0 || src >= len(d.program.Commands) {
+		return -1
+	}
+	return d.program.Commands[src].Offset
+}
+
+func (d *Debugger) report() {
+	if d.pc < 0 || d.pc >= d.cp.Len() {
+		fmt.Fprintln(d.out, "program finished")
+		return
+	}
+	fmt.Fprintf(d.out, "pc=%d (src byte %d) stack=%s\n", d.pc, d.sourceOffset(d.pc), d.m.stack)
+}
+
+func (d *Debugger) printHelp() {
+	fmt.Fprintln(d.out, `commands:
+  break <pc>|L<label>   set a breakpoint
+  delete <pc>           remove a breakpoint
+  step                  execute one instruction
+  next                  step, but step over CALL
+  continue              run until a breakpoint/watch/finish
+  bt                    print the call-frame stack
+  stack                 print the data stack
+  heap <addr> [len]     print heap cells
+  watch <addr>          break when a heap cell changes
+  disasm [n]            list the next n instructions
+  history               print command history
+  quit                  leave the debugger`)
+}
+
+func (d *Debugger) loadBreakpoints() {
+	if d.breakpointsPath == "" {
+		return
+	}
+	data, err := os.ReadFile(d.breakpointsPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(line); err == nil {
+			d.breaks[n] = true
+		}
+	}
+}
+
+func (d *Debugger) saveBreakpoints() {
+	if d.breakpointsPath == "" {
+		return
+	}
+	pcs := make([]int, 0, len(d.breaks))
+	for pc := range d.breaks {
+		pcs = append(pcs, pc)
+	}
+	sort.Ints(pcs)
+	var sb strings.Builder
+	for _, pc := range pcs {
+		fmt.Fprintf(&sb, "%d\n", pc)
+	}
+	os.WriteFile(d.breakpointsPath, []byte(sb.String()), 0644)
+}
+
+func (d *Debugger) loadHistory() {
+	if d.historyPath == "" {
+		return
+	}
+	data, err := os.ReadFile(d.historyPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			d.history = append(d.history, line)
+		}
+	}
+}
+
+func (d *Debugger) appendHistory(line string) {
+	d.history = append(d.history, line)
+	if d.historyPath == "" {
+		return
+	}
+	f, err := os.OpenFile(d.historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
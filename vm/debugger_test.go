@@ -0,0 +1,215 @@
+package vm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// subroutineProgram builds PUSH 1; CALL Lsub; PUTNUM; FINISH with Lsub:
+// PUSH 41; ADD; RET (computing 1+41=42), for tests that want a CALL/RET and
+// a label to break/step/next around.
+func subroutineProgram() Program {
+	const lsub = 7
+	return buildProgram(
+		Command{Cmd: CMD_PUSH, Num: num(1)},  // 0
+		Command{Cmd: CMD_CALL, Val: lsub},    // 1
+		Command{Cmd: CMD_PUTNUM},             // 2
+		Command{Cmd: CMD_FINISH},             // 3
+		Command{Cmd: CMD_MARK, Val: lsub},    // 4
+		Command{Cmd: CMD_PUSH, Num: num(41)}, // 5
+		Command{Cmd: CMD_ADD},                // 6
+		Command{Cmd: CMD_RET},                // 7
+	)
+}
+
+// newTestDebugger wires a Debugger over prog with scripted REPL input and no
+// persistence files, returning it along with the program's own stdout (what
+// PUTCHAR/PUTNUM write to, separate from the debugger's REPL transcript).
+func newTestDebugger(t *testing.T, prog Program, script string) (*Debugger, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+	var progOut, replOut bytes.Buffer
+	m := NewMachine(false, bytes.NewReader(nil), &progOut)
+	cp := prog.Compile()
+	d := NewDebugger(&m, &prog, cp, strings.NewReader(script), &replOut, "", "")
+	return d, &progOut, &replOut
+}
+
+// TestDebuggerBreakContinue drives break/continue/stack through the REPL and
+// checks both the transcript and that the program's own output is exactly
+// what Machine.Run would have produced, i.e. that stopping and resuming at a
+// breakpoint doesn't perturb execution.
+func TestDebuggerBreakContinue(t *testing.T) {
+	d, progOut, replOut := newTestDebugger(t, subroutineProgram(), "break L7\ncontinue\nstack\ncontinue\nquit\n")
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if progOut.String() != "42" {
+		t.Fatalf("program output = %q, want \"42\"", progOut.String())
+	}
+	transcript := replOut.String()
+	if !strings.Contains(transcript, "breakpoint hit at pc 4") {
+		t.Fatalf("transcript missing breakpoint hit; got:\n%s", transcript)
+	}
+	if !strings.Contains(transcript, "[1]") {
+		t.Fatalf("transcript missing stack contents at the breakpoint; got:\n%s", transcript)
+	}
+	if !strings.Contains(transcript, "program finished") {
+		t.Fatalf("transcript missing finish after the second continue; got:\n%s", transcript)
+	}
+}
+
+// TestDebuggerResolve exercises resolve() directly: a plain pc, a label that
+// resolves through a dropped MARK to the next surviving instruction, and the
+// error cases (bad label id, unknown label, out-of-range pc).
+func TestDebuggerResolve(t *testing.T) {
+	d, _, _ := newTestDebugger(t, subroutineProgram(), "")
+
+	if pc, ok := d.resolve("L7"); !ok || pc != 4 {
+		t.Fatalf("resolve(L7) = (%d, %v), want (4, true)", pc, ok)
+	}
+	if pc, ok := d.resolve("2"); !ok || pc != 2 {
+		t.Fatalf(`resolve("2") = (%d, %v), want (2, true)`, pc, ok)
+	}
+	if _, ok := d.resolve("L99"); ok {
+		t.Fatal("resolve(L99) should fail: no such label")
+	}
+	if _, ok := d.resolve("Lbogus"); ok {
+		t.Fatal("resolve(Lbogus) should fail: not a number after L")
+	}
+	if _, ok := d.resolve("1000"); ok {
+		t.Fatal("resolve(1000) should fail: pc out of range")
+	}
+}
+
+// TestDebuggerNextStepsOverCall checks that "next" on a CALL runs the whole
+// subroutine and stops back at the instruction after it, rather than landing
+// inside the callee the way "step" would -- the actual "step over CALL" loop
+// in cmdNext is easy to get wrong (e.g. comparing depth with the wrong sense,
+// or forgetting recursive calls could change it further) and a test that
+// only checked the final program output wouldn't catch stopping one
+// instruction too early or late.
+func TestDebuggerNextStepsOverCall(t *testing.T) {
+	d, progOut, replOut := newTestDebugger(t, subroutineProgram(), "step\nnext\ncontinue\nquit\n")
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if progOut.String() != "42" {
+		t.Fatalf("program output = %q, want \"42\"", progOut.String())
+	}
+	if d.m.frame.Len() != 0 {
+		t.Fatalf("frame depth after next = %d, want 0 (CALL should have fully unwound)", d.m.frame.Len())
+	}
+	// "next" should have landed on pc 2 (PUTNUM), the instruction right
+	// after CALL, with the subroutine's result (42) already on the stack.
+	if !strings.Contains(replOut.String(), "pc=2") {
+		t.Fatalf("transcript missing pc=2 after stepping over the CALL; got:\n%s", replOut.String())
+	}
+}
+
+// TestDebuggerWatch checks that watch reports a heap cell the first time it
+// changes and stays quiet once it stops changing, i.e. that checkWatches
+// actually diffs against the last reported value instead of firing on every
+// step once a watch address has ever changed.
+func TestDebuggerWatch(t *testing.T) {
+	prog := buildProgram(
+		Command{Cmd: CMD_PUSH, Num: num(0)},  // 0: addr
+		Command{Cmd: CMD_PUSH, Num: num(99)}, // 1: value
+		Command{Cmd: CMD_STORE},              // 2: heap[0] = 99
+		Command{Cmd: CMD_PUSH, Num: num(0)},  // 3: addr again, unrelated
+		Command{Cmd: CMD_DISCARD},            // 4: no further heap writes
+		Command{Cmd: CMD_FINISH},             // 5
+	)
+	d, _, replOut := newTestDebugger(t, prog, "watch 0\ncontinue\nquit\n")
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	transcript := replOut.String()
+	if strings.Count(transcript, "watch: heap[0] changed") != 1 {
+		t.Fatalf("expected exactly one watch report, got transcript:\n%s", transcript)
+	}
+	if !strings.Contains(transcript, "changed to 99") {
+		t.Fatalf("watch report missing the new value; got:\n%s", transcript)
+	}
+}
+
+// TestDebuggerPersistence checks that breakpoints and history survive across
+// Debugger instances when paths are given, and that passing "" (the
+// in-memory-only mode embedders are told to use) leaves no files behind.
+func TestDebuggerPersistence(t *testing.T) {
+	dir := t.TempDir()
+	bpPath := filepath.Join(dir, "breakpoints")
+	histPath := filepath.Join(dir, "history")
+
+	var out1 bytes.Buffer
+	m1 := NewMachine(false, bytes.NewReader(nil), &bytes.Buffer{})
+	prog := subroutineProgram()
+	cp := prog.Compile()
+	d1 := NewDebugger(&m1, &prog, cp, strings.NewReader("break 2\nquit\n"), &out1, bpPath, histPath)
+	if err := d1.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	var out2 bytes.Buffer
+	m2 := NewMachine(false, bytes.NewReader(nil), &bytes.Buffer{})
+	d2 := NewDebugger(&m2, &prog, cp, strings.NewReader(""), &out2, bpPath, histPath)
+	if !d2.breaks[2] {
+		t.Fatal("breakpoint at pc 2 did not survive across Debugger instances")
+	}
+	wantHistory := []string{"break 2", "quit"}
+	if len(d2.history) != len(wantHistory) || d2.history[0] != wantHistory[0] || d2.history[1] != wantHistory[1] {
+		t.Fatalf("history = %v, want %v", d2.history, wantHistory)
+	}
+}
+
+// TestDebuggerNoPersistence checks that "" paths (the in-memory-only mode
+// NewDebugger's doc comment promises to embedders that don't want filesystem
+// side effects) really do skip the filesystem.
+func TestDebuggerNoPersistence(t *testing.T) {
+	dir := t.TempDir()
+	_, _, _ = newTestDebugger(t, subroutineProgram(), "break 2\nquit\n")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no files written with empty paths, found %v", entries)
+	}
+}
+
+// TestDebuggerInspectionCommands exercises the remaining read-only REPL
+// commands (heap, bt, disasm, history, help, delete, and an unrecognized
+// command) that the other tests above don't happen to touch while driving
+// break/step/watch scenarios.
+func TestDebuggerInspectionCommands(t *testing.T) {
+	d, _, replOut := newTestDebugger(t, subroutineProgram(),
+		"break 3\nbt\ndelete 3\nstep\nheap 0\ndisasm 2\nhistory\nhelp\nbogus\nquit\n")
+	if err := d.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	transcript := replOut.String()
+
+	if !strings.Contains(transcript, "breakpoint at pc 3") {
+		t.Fatalf("transcript missing the break confirmation; got:\n%s", transcript)
+	}
+	if d.breaks[3] {
+		t.Fatal("delete 3 should have removed the breakpoint at pc 3")
+	}
+	if !strings.Contains(transcript, "heap[0] = 0") {
+		t.Fatalf("transcript missing the heap dump; got:\n%s", transcript)
+	}
+	if !strings.Contains(transcript, "op=") {
+		t.Fatalf("transcript missing the disasm listing; got:\n%s", transcript)
+	}
+	if !strings.Contains(transcript, "break 3") {
+		t.Fatalf("transcript missing its own command in the history listing; got:\n%s", transcript)
+	}
+	if !strings.Contains(transcript, "commands:") {
+		t.Fatalf("transcript missing the help text; got:\n%s", transcript)
+	}
+	if !strings.Contains(transcript, `unknown command "bogus"`) {
+		t.Fatalf("transcript missing the unknown-command message; got:\n%s", transcript)
+	}
+}
@@ -0,0 +1,76 @@
+package vm
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+// TestIntNumberOverflowPromotesToBigNumber checks that Add/Sub/Mul detect
+// int64 overflow and promote to bigNumber with the correct (non-wrapped)
+// result, rather than silently wrapping the way raw int64 arithmetic would.
+func TestIntNumberOverflowPromotesToBigNumber(t *testing.T) {
+	maxI64 := big.NewInt(math.MaxInt64)
+	minI64 := big.NewInt(math.MinInt64)
+
+	cases := []struct {
+		name string
+		got  Number
+		want *big.Int
+	}{
+		{
+			"add overflow",
+			intNumber(math.MaxInt64).Add(intNumber(1)),
+			new(big.Int).Add(maxI64, big.NewInt(1)),
+		},
+		{
+			"sub overflow",
+			intNumber(math.MinInt64).Sub(intNumber(1)),
+			new(big.Int).Sub(minI64, big.NewInt(1)),
+		},
+		{
+			"mul overflow",
+			intNumber(math.MaxInt64).Mul(intNumber(2)),
+			new(big.Int).Mul(maxI64, big.NewInt(2)),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bn, ok := c.got.(bigNumber)
+			if !ok {
+				t.Fatalf("result is %T, want bigNumber (did not promote)", c.got)
+			}
+			if bn.Cmp(bigNumber{c.want}) != 0 {
+				t.Fatalf("got %s, want %s", bn, c.want)
+			}
+		})
+	}
+}
+
+// TestIntNumberDivModMinInt64ByNegOne covers the one int64 division that
+// overflows: MinInt64 / -1 doesn't fit in an int64 (its magnitude is one
+// more than MaxInt64), so DivMod must also promote here rather than
+// returning the wrapped (and wrong) int64 result a raw n/m would.
+func TestIntNumberDivModMinInt64ByNegOne(t *testing.T) {
+	q, r := intNumber(math.MinInt64).DivMod(intNumber(-1))
+	wantQ := new(big.Int).Neg(big.NewInt(math.MinInt64))
+	if bq, ok := q.(bigNumber); !ok || bq.Cmp(bigNumber{wantQ}) != 0 {
+		t.Fatalf("quotient = %v (%T), want %s", q, q, wantQ)
+	}
+	if r.Sign() != 0 {
+		t.Fatalf("remainder = %v, want 0", r)
+	}
+}
+
+// TestNewNumberForcesBignum checks the -bignum escape hatch: even a literal
+// that comfortably fits in an int64 must come back as a bigNumber when
+// bignum is requested.
+func TestNewNumberForcesBignum(t *testing.T) {
+	n := NewNumber(true, big.NewInt(3))
+	if _, ok := n.(bigNumber); !ok {
+		t.Fatalf("NewNumber(true, 3) = %T, want bigNumber", n)
+	}
+	if n.Cmp(NewNumber(false, big.NewInt(3))) != 0 {
+		t.Fatalf("bignum 3 doesn't compare equal to int64 3")
+	}
+}
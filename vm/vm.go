@@ -0,0 +1,700 @@
+// Package vm holds Whitespace's runtime representation (Program, the
+// bytecode CompiledProgram it lowers to) and the Machine that executes it.
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+)
+
+const (
+	IMP_NONE = iota
+	IMP_STACK
+	IMP_ARITH
+	IMP_HEAP
+	IMP_FLOW
+	IMP_IO
+)
+
+const (
+	// Stack commands.
+	CMD_PUSH = iota
+	CMD_DUP
+	CMD_COPY
+	CMD_SWAP
+	CMD_DISCARD
+	CMD_SLIDE
+
+	// Arithmetic commands.
+	CMD_ADD
+	CMD_SUB
+	CMD_MUL
+	CMD_DIV
+	CMD_MOD
+
+	// Heap access.
+	CMD_STORE
+	CMD_RETRIEVE
+
+	// Flow control commands.
+	CMD_MARK
+	CMD_CALL
+	CMD_JMP
+	CMD_JMP_IF0
+	CMD_JMP_NEG
+	CMD_RET
+	CMD_FINISH
+
+	// I/O commands.
+	CMD_PUTCHAR
+	CMD_PUTNUM
+	CMD_READCHAR
+	CMD_READNUM
+)
+
+// Fused opcodes produced by the peephole pass in Compile. They only ever
+// appear in a CompiledProgram, never in the Command stream the parser
+// produces.
+const (
+	OP_ADDI   = iota + CMD_READNUM + 1 // PUSH n; ADD  -> pop a, push a+n
+	OP_STOREI                          // PUSH n; STORE -> pop addr, heap[addr]=n
+	OP_DOUBLE                          // DUP; ADD     -> pop a, push a+a
+)
+
+//--------------------------------------------------------------
+
+// Number abstracts over Whitespace's unbounded integers. intNumber is the
+// int64-backed fast path used by default; bigNumber wraps math/big.Int for
+// literals and results that don't fit in 63 bits. A parser's ParseValue
+// promotes a literal to bigNumber the moment it overflows, and -bignum
+// forces every value through bigNumber from the start, regardless of size.
+type Number interface {
+	Add(Number) Number
+	Sub(Number) Number
+	Mul(Number) Number
+	DivMod(Number) (Number, Number)
+	Cmp(Number) int
+	Sign() int
+	Bytes() []byte
+	String() string
+}
+
+// NewNumber picks the cheapest representation of v: intNumber unless
+// bignum was requested or v doesn't fit in an int64.
+func NewNumber(bignum bool, v *big.Int) Number {
+	if !bignum && v.IsInt64() {
+		return intNumber(v.Int64())
+	}
+	return bigNumber{v}
+}
+
+func toInt(n Number) int {
+	switch v := n.(type) {
+	case intNumber:
+		return int(v)
+	case bigNumber:
+		return int(v.Int64())
+	}
+	panic("vm: unsupported Number implementation")
+}
+
+type intNumber int64
+
+func (n intNumber) promote() bigNumber {
+	return bigNumber{big.NewInt(int64(n))}
+}
+
+func addOverflows(a, b int64) bool {
+	s := a + b
+	return (b > 0 && s < a) || (b < 0 && s > a)
+}
+
+func subOverflows(a, b int64) bool {
+	if b == -b { // b == 0 or b == MinInt64; -b itself would overflow.
+		return b != 0
+	}
+	return addOverflows(a, -b)
+}
+
+func mulOverflows(a, b int64) bool {
+	if a == 0 || b == 0 {
+		return false
+	}
+	if a == -1 && b == -1<<63 {
+		return true
+	}
+	p := a * b
+	return p/b != a
+}
+
+func (n intNumber) Add(o Number) Number {
+	m, ok := o.(intNumber)
+	if !ok {
+		return n.promote().Add(o)
+	}
+	if addOverflows(int64(n), int64(m)) {
+		return n.promote().Add(o)
+	}
+	return n + m
+}
+
+func (n intNumber) Sub(o Number) Number {
+	m, ok := o.(intNumber)
+	if !ok {
+		return n.promote().Sub(o)
+	}
+	if subOverflows(int64(n), int64(m)) {
+		return n.promote().Sub(o)
+	}
+	return n - m
+}
+
+func (n intNumber) Mul(o Number) Number {
+	m, ok := o.(intNumber)
+	if !ok {
+		return n.promote().Mul(o)
+	}
+	if mulOverflows(int64(n), int64(m)) {
+		return n.promote().Mul(o)
+	}
+	return n * m
+}
+
+func (n intNumber) DivMod(o Number) (Number, Number) {
+	m, ok := o.(intNumber)
+	if !ok {
+		return n.promote().DivMod(o)
+	}
+	if int64(n) == -1<<63 && int64(m) == -1 {
+		return n.promote().DivMod(o)
+	}
+	return n / m, n % m
+}
+
+func (n intNumber) Cmp(o Number) int {
+	m, ok := o.(intNumber)
+	if !ok {
+		return n.promote().Cmp(o)
+	}
+	switch {
+	case n < m:
+		return -1
+	case n > m:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (n intNumber) Sign() int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (n intNumber) Bytes() []byte {
+	return n.promote().Bytes()
+}
+
+func (n intNumber) String() string {
+	return strconv.FormatInt(int64(n), 10)
+}
+
+// bigNumber is the math/big.Int-backed Number implementation used once a
+// value overflows int64, or unconditionally when -bignum is set. Sign,
+// Bytes and String are satisfied by the embedded *big.Int directly.
+type bigNumber struct{ *big.Int }
+
+func (n bigNumber) operand(o Number) *big.Int {
+	if b, ok := o.(bigNumber); ok {
+		return b.Int
+	}
+	return big.NewInt(int64(o.(intNumber)))
+}
+
+func (n bigNumber) Add(o Number) Number { return bigNumber{new(big.Int).Add(n.Int, n.operand(o))} }
+func (n bigNumber) Sub(o Number) Number { return bigNumber{new(big.Int).Sub(n.Int, n.operand(o))} }
+func (n bigNumber) Mul(o Number) Number { return bigNumber{new(big.Int).Mul(n.Int, n.operand(o))} }
+
+func (n bigNumber) DivMod(o Number) (Number, Number) {
+	q, r := new(big.Int), new(big.Int)
+	q.QuoRem(n.Int, n.operand(o), r)
+	return bigNumber{q}, bigNumber{r}
+}
+
+func (n bigNumber) Cmp(o Number) int { return n.Int.Cmp(n.operand(o)) }
+
+//--------------------------------------------------------------
+
+// Command is one lowered Whitespace instruction. Val holds a label id or a
+// COPY/SLIDE count; Num holds the arbitrary-precision literal for CMD_PUSH
+// and is nil otherwise. Offset is the byte position in the source where the
+// command started, for debuggers to report which whitespace span a
+// breakpoint or fault corresponds to.
+type Command struct {
+	Imp    int
+	Cmd    int
+	Val    int
+	Num    Number
+	Cmdstr string
+	Offset int
+}
+
+// Program is what a parser builds: the command stream plus the label ->
+// command-index table Compile resolves jumps against.
+type Program struct {
+	Commands []Command
+	Labels   map[int]int
+}
+
+//--------------------------------------------------------------
+
+type Stack []Number
+
+func NewStack(capacity int) Stack {
+	return make([]Number, 0, capacity)
+}
+
+func (s Stack) String() string {
+	return fmt.Sprintf("%v", []Number(s))
+}
+
+func (s Stack) Get(idx int) Number {
+	return s[len(s)-(idx+1)]
+}
+
+func (s Stack) Put(idx int, value Number) {
+	s[len(s)-(idx+1)] = value
+}
+
+func (s *Stack) Pop() (n Number) {
+	n = (*s).Get(0)
+	*s = (*s)[:len(*s)-1]
+	return
+}
+
+func (s *Stack) Push(n Number) {
+	*s = append(*s, n)
+}
+
+func (s Stack) Len() int {
+	return len(s)
+}
+
+//--------------------------------------------------------------
+
+// Frame is the CALL/RET return-address stack. It holds plain instruction
+// indices rather than Numbers, since return addresses never participate in
+// Whitespace arithmetic.
+type Frame []int
+
+func NewFrame(capacity int) Frame {
+	return make([]int, 0, capacity)
+}
+
+func (f *Frame) Push(n int) {
+	*f = append(*f, n)
+}
+
+func (f *Frame) Pop() (n int) {
+	n = (*f)[len(*f)-1]
+	*f = (*f)[:len(*f)-1]
+	return
+}
+
+func (f Frame) Len() int {
+	return len(f)
+}
+
+//--------------------------------------------------------------
+
+// Heap is addressed by plain int indices but stores Numbers, zero-filling
+// newly grown cells so an unwritten address still reads as zero.
+type Heap struct {
+	cells  []Number
+	bignum bool
+}
+
+func NewHeap(bignum bool) Heap {
+	h := Heap{cells: make([]Number, 128), bignum: bignum}
+	h.fillZero(0, len(h.cells))
+	return h
+}
+
+func (h *Heap) fillZero(from int, to int) {
+	zero := NewNumber(h.bignum, new(big.Int))
+	for i := from; i < to; i++ {
+		h.cells[i] = zero
+	}
+}
+
+func (h Heap) Get(idx int) Number {
+	if idx > len(h.cells)-1 {
+		panic("Index out of range")
+	}
+	return h.cells[idx]
+}
+
+func (h *Heap) Put(idx int, value Number) {
+	if idx > cap(h.cells)-1 {
+		old := len(h.cells)
+		grown := make([]Number, (idx+1)*2)
+		copy(grown, h.cells)
+		h.cells = grown
+		h.fillZero(old, len(h.cells))
+	}
+	h.cells[idx] = value
+}
+
+func (h Heap) Len() int {
+	return len(h.cells)
+}
+
+//--------------------------------------------------------------
+
+// Machine is a Whitespace runtime: a data stack, a call-frame stack, a
+// heap, and the I/O streams READCHAR/PUTCHAR/READNUM/PUTNUM talk to.
+type Machine struct {
+	stack  Stack
+	frame  Frame
+	heap   Heap
+	in     io.Reader
+	out    io.Writer
+	bignum bool
+}
+
+// NewMachine builds a Machine. in/out back READCHAR/READNUM and
+// PUTCHAR/PUTNUM respectively, so embedders can point them at anything
+// (a test buffer, a socket) instead of stdio.
+func NewMachine(bignum bool, in io.Reader, out io.Writer) (m Machine) {
+	m = Machine{bignum: bignum, in: in, out: out}
+	m.stack = NewStack(20)
+	m.frame = NewFrame(20)
+	m.heap = NewHeap(bignum)
+	return
+}
+
+// number wraps v as a Number using this Machine's chosen backend, for
+// values that originate inside the machine (e.g. a byte read from stdin)
+// rather than from a parsed literal.
+func (m Machine) number(v int64) Number {
+	return NewNumber(m.bignum, big.NewInt(v))
+}
+
+//--------------------------------------------------------------
+
+// CompiledProgram is a lowered, label-free form of a Program: struct-of-arrays
+// of opcode/operand pairs with every JMP/CALL/JMP_IF0/JMP_NEG target resolved
+// to a direct instruction index, ready for Machine to dispatch through a
+// jump table instead of switching on Imp/Cmd and looking up Program.Labels.
+// Arg carries pc targets and COPY/SLIDE counts; Num carries the arbitrary-
+// precision literal for CMD_PUSH/OP_ADDI/OP_STOREI (nil everywhere else).
+// Src maps each compiled instruction back to the index of the Program.Commands
+// entry it was lowered from, so a debugger can report source positions; a
+// fused pair points at its first half.
+type CompiledProgram struct {
+	op  []uint32
+	arg []int
+	num []Number
+	src []int
+}
+
+func (cp CompiledProgram) Len() int {
+	return len(cp.op)
+}
+
+// Op, Arg and Num expose instruction i's opcode, arg (a resolved pc target
+// or a COPY/SLIDE count) and literal operand, for callers outside the
+// package that lower a CompiledProgram to something else entirely (e.g.
+// package wasm) instead of driving it through Step.
+func (cp CompiledProgram) Op(i int) uint32  { return cp.op[i] }
+func (cp CompiledProgram) Arg(i int) int    { return cp.arg[i] }
+func (cp CompiledProgram) Num(i int) Number { return cp.num[i] }
+
+// Compile lowers the program to a CompiledProgram: it drops CMD_MARK
+// (labels become plain instruction indices), resolves every jump/call
+// target to that index, and runs a peephole pass that fuses a handful of
+// common idioms and collapses JMP-to-JMP chains.
+func (program Program) Compile() CompiledProgram {
+	remap := make([]int, len(program.Commands))
+	cp := CompiledProgram{
+		op:  make([]uint32, 0, len(program.Commands)),
+		arg: make([]int, 0, len(program.Commands)),
+		num: make([]Number, 0, len(program.Commands)),
+		src: make([]int, 0, len(program.Commands)),
+	}
+	for i, cmd := range program.Commands {
+		remap[i] = len(cp.op)
+		if cmd.Cmd == CMD_MARK {
+			continue
+		}
+		cp.op = append(cp.op, uint32(cmd.Cmd))
+		cp.arg = append(cp.arg, cmd.Val)
+		cp.num = append(cp.num, cmd.Num)
+		cp.src = append(cp.src, i)
+	}
+	for i, op := range cp.op {
+		switch op {
+		case CMD_CALL, CMD_JMP, CMD_JMP_IF0, CMD_JMP_NEG:
+			cp.arg[i] = remap[program.Labels[cp.arg[i]]]
+		}
+	}
+	cp = cp.peephole()
+	cp.collapseJumpChains()
+	return cp
+}
+
+// peephole fuses PUSH+ADD, PUSH+STORE and DUP+ADD pairs into single
+// instructions. A pair is only fused when nothing can jump to its second
+// half, since that half stops existing afterwards.
+func (cp CompiledProgram) peephole() CompiledProgram {
+	isTarget := make([]bool, len(cp.op)+1)
+	for i, op := range cp.op {
+		switch op {
+		case CMD_CALL, CMD_JMP, CMD_JMP_IF0, CMD_JMP_NEG:
+			isTarget[cp.arg[i]] = true
+		}
+	}
+
+	out := CompiledProgram{
+		op:  make([]uint32, 0, len(cp.op)),
+		arg: make([]int, 0, len(cp.arg)),
+		num: make([]Number, 0, len(cp.num)),
+		src: make([]int, 0, len(cp.src)),
+	}
+	remap := make([]int, len(cp.op)+1)
+
+	i := 0
+	for i < len(cp.op) {
+		remap[i] = len(out.op)
+		if i+1 < len(cp.op) && !isTarget[i+1] {
+			switch {
+			case cp.op[i] == CMD_PUSH && cp.op[i+1] == CMD_ADD:
+				out.op = append(out.op, OP_ADDI)
+				out.arg = append(out.arg, 0)
+				out.num = append(out.num, cp.num[i])
+				out.src = append(out.src, cp.src[i])
+				remap[i+1] = len(out.op) - 1
+				i += 2
+				continue
+			case cp.op[i] == CMD_PUSH && cp.op[i+1] == CMD_STORE:
+				out.op = append(out.op, OP_STOREI)
+				out.arg = append(out.arg, 0)
+				out.num = append(out.num, cp.num[i])
+				out.src = append(out.src, cp.src[i])
+				remap[i+1] = len(out.op) - 1
+				i += 2
+				continue
+			case cp.op[i] == CMD_DUP && cp.op[i+1] == CMD_ADD:
+				out.op = append(out.op, OP_DOUBLE)
+				out.arg = append(out.arg, 0)
+				out.num = append(out.num, nil)
+				out.src = append(out.src, cp.src[i])
+				remap[i+1] = len(out.op) - 1
+				i += 2
+				continue
+			}
+		}
+		out.op = append(out.op, cp.op[i])
+		out.arg = append(out.arg, cp.arg[i])
+		out.num = append(out.num, cp.num[i])
+		out.src = append(out.src, cp.src[i])
+		i += 1
+	}
+	remap[len(cp.op)] = len(out.op)
+
+	for i, op := range out.op {
+		switch op {
+		case CMD_CALL, CMD_JMP, CMD_JMP_IF0, CMD_JMP_NEG:
+			out.arg[i] = remap[out.arg[i]]
+		}
+	}
+	return out
+}
+
+// collapseJumpChains rewrites "JMP to a JMP" (and CALL/JMP_IF0/JMP_NEG to a
+// JMP) so each jump lands on its final destination directly, in one hop.
+func (cp CompiledProgram) collapseJumpChains() {
+	for i, op := range cp.op {
+		switch op {
+		case CMD_CALL, CMD_JMP, CMD_JMP_IF0, CMD_JMP_NEG:
+			target := cp.arg[i]
+			visited := map[int]bool{}
+			for target < len(cp.op) && cp.op[target] == CMD_JMP && !visited[target] {
+				visited[target] = true
+				target = cp.arg[target]
+			}
+			cp.arg[i] = target
+		}
+	}
+}
+
+// opFunc is one entry of the computed-goto-style jump table Step dispatches
+// through: it performs the instruction at pc and returns the next pc (or -1
+// to stop). num is the instruction's literal operand, nil where unused.
+type opFunc func(m *Machine, arg int, num Number, pc int) int
+
+var compiledDispatch = [...]opFunc{
+	CMD_PUSH: func(m *Machine, arg int, num Number, pc int) int { m.stack.Push(num); return pc },
+	CMD_DUP: func(m *Machine, arg int, num Number, pc int) int {
+		m.stack.Push(m.stack.Get(0))
+		return pc
+	},
+	CMD_COPY: func(m *Machine, arg int, num Number, pc int) int {
+		if 1+arg > m.stack.Len() {
+			panic("Index out of range")
+		}
+		m.stack.Push(m.number(int64(arg)))
+		return pc
+	},
+	CMD_SWAP: func(m *Machine, arg int, num Number, pc int) int {
+		s := m.stack
+		s[len(s)-1], s[len(s)-2] = s[len(s)-2], s[len(s)-1]
+		return pc
+	},
+	CMD_DISCARD: func(m *Machine, arg int, num Number, pc int) int { m.stack.Pop(); return pc },
+	CMD_SLIDE: func(m *Machine, arg int, num Number, pc int) int {
+		if 1+arg > m.stack.Len() {
+			panic("Index out of range")
+		}
+		idx := m.stack.Len() - (1 + arg)
+		m.stack = append(m.stack[:idx], m.stack[idx+1:]...)
+		return pc
+	},
+	CMD_ADD: func(m *Machine, arg int, num Number, pc int) int {
+		n2 := m.stack.Pop()
+		n1 := m.stack.Pop()
+		m.stack.Push(n1.Add(n2))
+		return pc
+	},
+	CMD_SUB: func(m *Machine, arg int, num Number, pc int) int {
+		n2 := m.stack.Pop()
+		n1 := m.stack.Pop()
+		m.stack.Push(n1.Sub(n2))
+		return pc
+	},
+	CMD_MUL: func(m *Machine, arg int, num Number, pc int) int {
+		n2 := m.stack.Pop()
+		n1 := m.stack.Pop()
+		m.stack.Push(n1.Mul(n2))
+		return pc
+	},
+	CMD_DIV: func(m *Machine, arg int, num Number, pc int) int {
+		n2 := m.stack.Pop()
+		n1 := m.stack.Pop()
+		q, _ := n1.DivMod(n2)
+		m.stack.Push(q)
+		return pc
+	},
+	CMD_MOD: func(m *Machine, arg int, num Number, pc int) int {
+		n2 := m.stack.Pop()
+		n1 := m.stack.Pop()
+		_, r := n1.DivMod(n2)
+		m.stack.Push(r)
+		return pc
+	},
+	CMD_STORE: func(m *Machine, arg int, num Number, pc int) int {
+		value := m.stack.Pop()
+		address := m.stack.Pop()
+		m.heap.Put(toInt(address), value)
+		return pc
+	},
+	CMD_RETRIEVE: func(m *Machine, arg int, num Number, pc int) int {
+		address := m.stack.Pop()
+		m.stack.Push(m.heap.Get(toInt(address)))
+		return pc
+	},
+	CMD_CALL: func(m *Machine, arg int, num Number, pc int) int { m.frame.Push(pc); return arg },
+	CMD_JMP:  func(m *Machine, arg int, num Number, pc int) int { return arg },
+	CMD_JMP_IF0: func(m *Machine, arg int, num Number, pc int) int {
+		if m.stack.Pop().Sign() == 0 {
+			return arg
+		}
+		return pc
+	},
+	CMD_JMP_NEG: func(m *Machine, arg int, num Number, pc int) int {
+		if m.stack.Pop().Sign() < 0 {
+			return arg
+		}
+		return pc
+	},
+	CMD_RET: func(m *Machine, arg int, num Number, pc int) int {
+		if m.frame.Len() == 0 {
+			panic("Cannot return")
+		}
+		return m.frame.Pop()
+	},
+	CMD_FINISH: func(m *Machine, arg int, num Number, pc int) int { return -1 },
+	CMD_PUTCHAR: func(m *Machine, arg int, num Number, pc int) int {
+		fmt.Fprintf(m.out, "%c", toInt(m.stack.Pop()))
+		return pc
+	},
+	CMD_PUTNUM: func(m *Machine, arg int, num Number, pc int) int {
+		fmt.Fprint(m.out, m.stack.Pop())
+		return pc
+	},
+	CMD_READCHAR: func(m *Machine, arg int, num Number, pc int) int {
+		var c int
+		fmt.Fscanf(m.in, "%c", &c)
+		m.heap.Put(toInt(m.stack.Pop()), m.number(int64(c)))
+		return pc
+	},
+	CMD_READNUM: func(m *Machine, arg int, num Number, pc int) int {
+		var n big.Int
+		fmt.Fscanf(m.in, "%d", &n)
+		m.heap.Put(toInt(m.stack.Pop()), NewNumber(m.bignum, &n))
+		return pc
+	},
+	OP_ADDI: func(m *Machine, arg int, num Number, pc int) int {
+		m.stack.Push(m.stack.Pop().Add(num))
+		return pc
+	},
+	OP_STOREI: func(m *Machine, arg int, num Number, pc int) int {
+		m.heap.Put(toInt(m.stack.Pop()), num)
+		return pc
+	},
+	OP_DOUBLE: func(m *Machine, arg int, num Number, pc int) int {
+		n := m.stack.Pop()
+		m.stack.Push(n.Add(n))
+		return pc
+	},
+}
+
+// Step executes the single instruction of cp at pc and returns the next pc
+// (-1 once the program has finished), for callers that want to drive
+// execution one instruction at a time (e.g. a debugger).
+func (m *Machine) Step(cp CompiledProgram, pc int) int {
+	return compiledDispatch[cp.op[pc]](m, cp.arg[pc], cp.num[pc], pc+1)
+}
+
+// Run executes cp to completion, honoring ctx cancellation between
+// instructions, and reports how many instructions it ran.
+func (m Machine) Run(ctx context.Context, cp CompiledProgram) (uint64, error) {
+	var insns uint64
+	pc := 0
+	for pc >= 0 && pc < cp.Len() {
+		select {
+		case <-ctx.Done():
+			return insns, ctx.Err()
+		default:
+		}
+		insns += 1
+		pc = m.Step(cp, pc)
+	}
+	return insns, nil
+}
+
+// RunCompiled is Run without cancellation, for callers (like -bench) that
+// don't need it.
+func (m Machine) RunCompiled(cp CompiledProgram) uint64 {
+	insns, _ := m.Run(context.Background(), cp)
+	return insns
+}
@@ -0,0 +1,175 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+)
+
+// buildProgram assembles cmds into a Program, deriving Labels from any
+// CMD_MARK commands the way the parser does (a label maps to the index of
+// its own MARK command; Compile resolves that to the next surviving
+// instruction), so tests can write jump/call targets as plain label ids
+// without hand-computing instruction indices.
+func buildProgram(cmds ...Command) Program {
+	labels := make(map[int]int)
+	for i, c := range cmds {
+		if c.Cmd == CMD_MARK {
+			labels[c.Val] = i
+		}
+	}
+	return Program{Commands: cmds, Labels: labels}
+}
+
+func num(n int64) Number {
+	return NewNumber(false, big.NewInt(n))
+}
+
+// runProgram compiles and runs prog to completion, returning everything it
+// wrote via PUTCHAR/PUTNUM and the instruction count Run reports.
+func runProgram(t *testing.T, prog Program) (string, uint64) {
+	t.Helper()
+	var out bytes.Buffer
+	m := NewMachine(false, bytes.NewReader(nil), &out)
+	insns, err := m.Run(context.Background(), prog.Compile())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return out.String(), insns
+}
+
+// TestPeepholeFusions runs the three idioms Compile's peephole pass fuses
+// (PUSH+ADD -> OP_ADDI, DUP+ADD -> OP_DOUBLE, PUSH+STORE -> OP_STOREI)
+// through Compile+Run and checks the result, not just that Compile doesn't
+// panic: a wrong fusion (e.g. swapped operand order, a stale arg) would
+// still compile cleanly but produce the wrong answer.
+func TestPeepholeFusions(t *testing.T) {
+	t.Run("push add", func(t *testing.T) {
+		// PUSH 3; PUSH 4; ADD fuses the trailing PUSH+ADD into OP_ADDI(4),
+		// leaving PUSH 3; OP_ADDI(4) -> pop 3, push 3+4.
+		prog := buildProgram(
+			Command{Cmd: CMD_PUSH, Num: num(3)},
+			Command{Cmd: CMD_PUSH, Num: num(4)},
+			Command{Cmd: CMD_ADD},
+			Command{Cmd: CMD_PUTNUM},
+			Command{Cmd: CMD_FINISH},
+		)
+		if got, _ := runProgram(t, prog); got != "7" {
+			t.Fatalf("PUSH 3; PUSH 4; ADD: got %q, want \"7\"", got)
+		}
+	})
+
+	t.Run("dup add", func(t *testing.T) {
+		// PUSH 5; DUP; ADD fuses DUP+ADD into OP_DOUBLE -> pop 5, push 5+5.
+		prog := buildProgram(
+			Command{Cmd: CMD_PUSH, Num: num(5)},
+			Command{Cmd: CMD_DUP},
+			Command{Cmd: CMD_ADD},
+			Command{Cmd: CMD_PUTNUM},
+			Command{Cmd: CMD_FINISH},
+		)
+		if got, _ := runProgram(t, prog); got != "10" {
+			t.Fatalf("PUSH 5; DUP; ADD: got %q, want \"10\"", got)
+		}
+	})
+
+	t.Run("push store", func(t *testing.T) {
+		// PUSH 500; PUSH 42; STORE fuses the trailing PUSH+STORE into
+		// OP_STOREI(42) -> pop address 500, heap[500] = 42.
+		prog := buildProgram(
+			Command{Cmd: CMD_PUSH, Num: num(500)},
+			Command{Cmd: CMD_PUSH, Num: num(42)},
+			Command{Cmd: CMD_STORE},
+			Command{Cmd: CMD_PUSH, Num: num(500)},
+			Command{Cmd: CMD_RETRIEVE},
+			Command{Cmd: CMD_PUTNUM},
+			Command{Cmd: CMD_FINISH},
+		)
+		if got, _ := runProgram(t, prog); got != "42" {
+			t.Fatalf("PUSH 500; PUSH 42; STORE; ...RETRIEVE: got %q, want \"42\"", got)
+		}
+	})
+}
+
+// TestCollapseJumpChains builds a JMP-to-JMP-to-JMP chain and checks not
+// just that it reaches the right destination, but that collapseJumpChains
+// actually collapsed it: an uncollapsed chain would still land on the same
+// PUSH/PUTNUM and produce the same output, just by executing two extra JMP
+// hops, so the instruction count Run reports is what catches a regression
+// here.
+func TestCollapseJumpChains(t *testing.T) {
+	const l0, l1, l2 = 0, 1, 2
+	prog := buildProgram(
+		Command{Cmd: CMD_JMP, Val: l0}, // 0: JMP l0 -> collapses straight to 2
+		Command{Cmd: CMD_MARK, Val: l2},
+		Command{Cmd: CMD_PUSH, Num: num(99)}, // 2
+		Command{Cmd: CMD_PUTNUM},
+		Command{Cmd: CMD_FINISH},
+		Command{Cmd: CMD_MARK, Val: l1},
+		Command{Cmd: CMD_JMP, Val: l2}, // JMP l1 -> l2
+		Command{Cmd: CMD_MARK, Val: l0},
+		Command{Cmd: CMD_JMP, Val: l1}, // JMP l0 -> l1 -> l2
+	)
+	got, insns := runProgram(t, prog)
+	if got != "99" {
+		t.Fatalf("got %q, want \"99\"", got)
+	}
+	const want = 4 // JMP, PUSH, PUTNUM, FINISH - no intermediate JMP hops
+	if insns != want {
+		t.Fatalf("ran %d instructions, want %d (JMP chain wasn't collapsed to a single hop)", insns, want)
+	}
+}
+
+// TestJumpToTrailingLabel covers a MARK that's the very last command, with
+// nothing after it -- an entirely idiomatic way to write a loop/if-end
+// label (e.g. "PUSH 1; JMP_IF0 L1; PUSH 99; PUTNUM; MARK L1"). Dropping
+// CMD_MARK remaps such a label to len(cp.op), one past the last real
+// instruction, which used to make collapseJumpChains index cp.op out of
+// bounds trying to see whether that (nonexistent) instruction was itself a
+// JMP to collapse through.
+func TestJumpToTrailingLabel(t *testing.T) {
+	const l1 = 1
+
+	t.Run("jump not taken", func(t *testing.T) {
+		prog := buildProgram(
+			Command{Cmd: CMD_PUSH, Num: num(1)},
+			Command{Cmd: CMD_JMP_IF0, Val: l1},
+			Command{Cmd: CMD_PUSH, Num: num(99)},
+			Command{Cmd: CMD_PUTNUM},
+			Command{Cmd: CMD_MARK, Val: l1},
+		)
+		if got, _ := runProgram(t, prog); got != "99" {
+			t.Fatalf("got %q, want \"99\"", got)
+		}
+	})
+
+	t.Run("jump taken, landing past the end", func(t *testing.T) {
+		prog := buildProgram(
+			Command{Cmd: CMD_PUSH, Num: num(0)},
+			Command{Cmd: CMD_JMP_IF0, Val: l1},
+			Command{Cmd: CMD_PUSH, Num: num(99)},
+			Command{Cmd: CMD_PUTNUM},
+			Command{Cmd: CMD_MARK, Val: l1},
+		)
+		if got, _ := runProgram(t, prog); got != "" {
+			t.Fatalf("got %q, want \"\" (jump should skip straight to program end)", got)
+		}
+	})
+
+	t.Run("jump chain ending on a trailing label", func(t *testing.T) {
+		// JMP l0 -> l1 -> trailing MARK, so collapseJumpChains itself walks
+		// off the end of cp.op, not just the initial label remap.
+		const l0 = 0
+		prog := buildProgram(
+			Command{Cmd: CMD_JMP, Val: l0}, // 0: JMP l0 -> l1 -> end
+			Command{Cmd: CMD_PUTCHAR},      // never reached
+			Command{Cmd: CMD_MARK, Val: l0},
+			Command{Cmd: CMD_JMP, Val: l1}, // JMP l0 -> l1
+			Command{Cmd: CMD_MARK, Val: l1},
+		)
+		if got, _ := runProgram(t, prog); got != "" {
+			t.Fatalf("got %q, want \"\"", got)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package wasm
+
+// This file is the hand-rolled encoder for the pieces of the WASM binary
+// format (module/type/import/function/memory/global/export/code sections,
+// and the instruction opcodes wasm.go emits) that translate.go needs. It
+// knows nothing about Whitespace; see wasm.go for that.
+
+// Section ids, in the order they must appear in a module.
+const (
+	secType     = 1
+	secImport   = 2
+	secFunction = 3
+	secMemory   = 5
+	secGlobal   = 6
+	secExport   = 7
+	secCode     = 10
+)
+
+// Value and block types.
+const (
+	valTypeI32  = 0x7f
+	valTypeI64  = 0x7e
+	blockVoid   = 0x40
+	funcTypeTag = 0x60
+)
+
+// Instruction opcodes, named after their text-format mnemonic.
+const (
+	opUnreachable = 0x00
+
+	opBlock   = 0x02
+	opLoop    = 0x03
+	opIf      = 0x04
+	opElse    = 0x05
+	opEnd     = 0x0b
+	opBr      = 0x0c
+	opBrIf    = 0x0d
+	opBrTable = 0x0e
+	opReturn  = 0x0f
+	opCall    = 0x10
+	opDrop    = 0x1a
+
+	opLocalGet  = 0x20
+	opLocalSet  = 0x21
+	opGlobalGet = 0x23
+	opGlobalSet = 0x24
+
+	opI32Load  = 0x28
+	opI64Load  = 0x29
+	opI32Store = 0x36
+	opI64Store = 0x37
+
+	opMemorySize = 0x3f
+	opMemoryGrow = 0x40
+
+	opI32Const = 0x41
+	opI64Const = 0x42
+
+	opI32Eqz = 0x45
+	opI32GtS = 0x4a
+	opI32LeS = 0x4c
+	opI32GeS = 0x4e
+	opI64Eqz = 0x50
+	opI64Eq  = 0x51
+	opI64LtS = 0x53
+
+	opI32Add = 0x6a
+	opI32Sub = 0x6b
+	opI32Mul = 0x6c
+
+	opI64Add  = 0x7c
+	opI64Sub  = 0x7d
+	opI64Mul  = 0x7e
+	opI64DivS = 0x7f
+	opI64RemS = 0x81
+	opI64And  = 0x83
+	opI64Xor  = 0x85
+
+	opI32WrapI64 = 0xa7
+)
+
+// buf is an append-only byte builder with the LEB128 and instruction
+// helpers the section/function encoders share. It mirrors how asm.go
+// builds output through a bufio.Writer, just with []byte instead of a
+// file.
+type buf struct {
+	b []byte
+}
+
+func (c *buf) raw(bs ...byte) *buf {
+	c.b = append(c.b, bs...)
+	return c
+}
+
+func (c *buf) bytes() []byte { return c.b }
+
+// uleb appends v as unsigned LEB128, used for indices, counts and byte
+// offsets.
+func (c *buf) uleb(v uint32) *buf {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b |= 0x80
+		}
+		c.b = append(c.b, b)
+		if v == 0 {
+			return c
+		}
+	}
+}
+
+// sleb appends v as signed LEB128, used for i32.const/i64.const operands.
+func (c *buf) sleb(v int64) *buf {
+	more := true
+	for more {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			more = false
+		} else {
+			b |= 0x80
+		}
+		c.b = append(c.b, b)
+	}
+	return c
+}
+
+// vec prepends a ULEB128 length to a byte slice, the encoding WASM uses for
+// every section body and every "vector of X" within one.
+func vec(items [][]byte) []byte {
+	var out buf
+	out.uleb(uint32(len(items)))
+	for _, it := range items {
+		out.raw(it...)
+	}
+	return out.bytes()
+}
+
+// section wraps body in the id+size+body framing every top-level section
+// uses.
+func section(id byte, body []byte) []byte {
+	var out buf
+	out.raw(id)
+	out.uleb(uint32(len(body)))
+	out.raw(body...)
+	return out.bytes()
+}
+
+// name encodes a WASM "name": a ULEB128 byte length followed by UTF-8 bytes.
+func name(s string) []byte {
+	var out buf
+	out.uleb(uint32(len(s)))
+	out.raw([]byte(s)...)
+	return out.bytes()
+}
+
+func (c *buf) i32Const(v int32) *buf     { return c.raw(opI32Const).sleb(int64(v)) }
+func (c *buf) i64Const(v int64) *buf     { return c.raw(opI64Const).sleb(v) }
+func (c *buf) localGet(idx uint32) *buf  { return c.raw(opLocalGet).uleb(idx) }
+func (c *buf) localSet(idx uint32) *buf  { return c.raw(opLocalSet).uleb(idx) }
+func (c *buf) globalGet(idx uint32) *buf { return c.raw(opGlobalGet).uleb(idx) }
+func (c *buf) globalSet(idx uint32) *buf { return c.raw(opGlobalSet).uleb(idx) }
+
+// memarg is the alignment+offset pair every load/store carries; align is a
+// power-of-two exponent (3 == 8-byte natural alignment for i64).
+func (c *buf) memarg(align, offset uint32) *buf { return c.uleb(align).uleb(offset) }
+
+func (c *buf) i64Load(offset uint32) *buf  { return c.raw(opI64Load).memarg(3, offset) }
+func (c *buf) i64Store(offset uint32) *buf { return c.raw(opI64Store).memarg(3, offset) }
+func (c *buf) i32Load(offset uint32) *buf  { return c.raw(opI32Load).memarg(2, offset) }
+func (c *buf) i32Store(offset uint32) *buf { return c.raw(opI32Store).memarg(2, offset) }
+
+func (c *buf) block() *buf            { return c.raw(opBlock, blockVoid) }
+func (c *buf) loop() *buf             { return c.raw(opLoop, blockVoid) }
+func (c *buf) ifVoid() *buf           { return c.raw(opIf, blockVoid) }
+func (c *buf) els() *buf              { return c.raw(opElse) }
+func (c *buf) end() *buf              { return c.raw(opEnd) }
+func (c *buf) br(label uint32) *buf   { return c.raw(opBr).uleb(label) }
+func (c *buf) brIf(label uint32) *buf { return c.raw(opBrIf).uleb(label) }
+
+func (c *buf) brTable(labels []uint32, def uint32) *buf {
+	c.raw(opBrTable).uleb(uint32(len(labels)))
+	for _, l := range labels {
+		c.uleb(l)
+	}
+	return c.uleb(def)
+}
+
+func (c *buf) call(funcIdx uint32) *buf { return c.raw(opCall).uleb(funcIdx) }
+
+// memoryGrow/memorySize carry a reserved memory-index byte, always 0 since
+// a module only ever declares one memory here.
+func (c *buf) memoryGrow() *buf { return c.raw(opMemoryGrow, 0) }
+func (c *buf) memorySize() *buf { return c.raw(opMemorySize, 0) }
@@ -0,0 +1,285 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+
+	"github.com/kinu/whitespace/asm"
+	"github.com/kinu/whitespace/parser"
+	"github.com/kinu/whitespace/vm"
+)
+
+// mustProgram assembles mnemonic source (see package asm) into a Program,
+// for readable test fixtures instead of hand-typed space/tab literals.
+func mustProgram(t *testing.T, mnemonic string) *vm.Program {
+	t.Helper()
+	asmProgram, err := asm.Assemble(bytes.NewReader([]byte(mnemonic)))
+	if err != nil {
+		t.Fatalf("asm.Assemble(%q): %v", mnemonic, err)
+	}
+	var src bytes.Buffer
+	if err := asm.Emit(asmProgram, &src); err != nil {
+		t.Fatalf("asm.Emit: %v", err)
+	}
+	program, err := parser.Parse(&src)
+	if err != nil {
+		t.Fatalf("parser.Parse: %v", err)
+	}
+	return program
+}
+
+func mustCompile(t *testing.T, mnemonic string) ([]byte, error) {
+	t.Helper()
+	return Compile(*mustProgram(t, mnemonic))
+}
+
+// runModule instantiates a compiled module under wazero (a real WASM
+// runtime, not a parser-level fake) and runs it, supplying the same
+// env.putchar/putnum/readchar/readnum imports the JS shim in the verify
+// skill does. It returns everything the program wrote via PUTCHAR/PUTNUM.
+func runModule(t *testing.T, module []byte) string {
+	t.Helper()
+	ctx := context.Background()
+	rt := wazero.NewRuntime(ctx)
+	defer rt.Close(ctx)
+
+	var out bytes.Buffer
+	_, err := rt.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(func(c int64) { out.WriteByte(byte(c)) }).Export("putchar").
+		NewFunctionBuilder().WithFunc(func(n int64) { out.WriteString(strconv.FormatInt(n, 10)) }).Export("putnum").
+		NewFunctionBuilder().WithFunc(func() int64 { return 0 }).Export("readchar").
+		NewFunctionBuilder().WithFunc(func() int64 { return 0 }).Export("readnum").
+		Instantiate(ctx)
+	if err != nil {
+		t.Fatalf("building env host module: %v", err)
+	}
+
+	instance, err := rt.Instantiate(ctx, module)
+	if err != nil {
+		t.Fatalf("instantiating module: %v", err)
+	}
+	run := instance.ExportedFunction("run")
+	if run == nil {
+		t.Fatalf("module does not export run")
+	}
+	if _, err := run.Call(ctx); err != nil {
+		t.Fatalf("calling run: %v", err)
+	}
+	return out.String()
+}
+
+// runModuleExpectTrap instantiates and calls run the same way runModule
+// does, but returns the call's error instead of failing the test on it, for
+// tests asserting that a module traps rather than runs to completion.
+func runModuleExpectTrap(t *testing.T, module []byte) error {
+	t.Helper()
+	ctx := context.Background()
+	rt := wazero.NewRuntime(ctx)
+	defer rt.Close(ctx)
+
+	_, err := rt.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(func(c int64) {}).Export("putchar").
+		NewFunctionBuilder().WithFunc(func(n int64) {}).Export("putnum").
+		NewFunctionBuilder().WithFunc(func() int64 { return 0 }).Export("readchar").
+		NewFunctionBuilder().WithFunc(func() int64 { return 0 }).Export("readnum").
+		Instantiate(ctx)
+	if err != nil {
+		t.Fatalf("building env host module: %v", err)
+	}
+
+	instance, err := rt.Instantiate(ctx, module)
+	if err != nil {
+		t.Fatalf("instantiating module: %v", err)
+	}
+	_, err = instance.ExportedFunction("run").Call(ctx)
+	return err
+}
+
+func TestCompileWellFormedHeader(t *testing.T) {
+	module, err := mustCompile(t, "PUSH 72\nPUTCHAR\nFINISH\n")
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if len(module) < 8 {
+		t.Fatalf("module too short: %d bytes", len(module))
+	}
+	if !bytes.Equal(module[:4], []byte{0x00, 0x61, 0x73, 0x6d}) {
+		t.Fatalf("bad magic: %x", module[:4])
+	}
+	if !bytes.Equal(module[4:8], []byte{0x01, 0x00, 0x00, 0x00}) {
+		t.Fatalf("bad version: %x", module[4:8])
+	}
+}
+
+func TestCompileLoopAndCall(t *testing.T) {
+	// A self-call followed by a countdown loop exercises CALL/RET, JMP_IF0
+	// and the ADDI/STOREI/DOUBLE peephole fusions all in one program.
+	_, err := mustCompile(t, `
+PUSH 5
+MARK L0
+DUP
+JMP_IF0 L1
+DUP
+PUTNUM
+PUSH 1
+SUB
+JMP L0
+MARK L1
+DISCARD
+FINISH
+`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+}
+
+func TestCompileRejectsOversizeLiteral(t *testing.T) {
+	// 2^64 doesn't fit in an i64; the wasm backend has no bignum path, so
+	// Compile must reject it instead of silently truncating.
+	_, err := mustCompile(t, "PUSH 18446744073709551616\nFINISH\n")
+	if err == nil {
+		t.Fatalf("Compile: expected an error for an oversize PUSH literal, got none")
+	}
+}
+
+// TestCompileRunMatchesInterpreter actually executes each compiled module
+// under wazero (a real WASM runtime) and checks its PUTCHAR/PUTNUM output is
+// byte-identical to vm.Machine.Run's output for the same program, per the
+// package doc comment's documented contract. The earlier tests only ever
+// inspected Compile's error return or the header bytes, so a module that
+// compiled cleanly but was wrong (or didn't even validate) would have slipped
+// through.
+func TestCompileRunMatchesInterpreter(t *testing.T) {
+	cases := []struct {
+		name     string
+		mnemonic string
+	}{
+		{"putchar", "PUSH 72\nPUTCHAR\nFINISH\n"},
+		{
+			"loop and call",
+			`
+PUSH 5
+MARK L0
+DUP
+JMP_IF0 L1
+DUP
+PUTNUM
+PUSH 1
+SUB
+JMP L0
+MARK L1
+DISCARD
+FINISH
+`,
+		},
+		{
+			// Address 500 is past the module's initialHeapCells (128),
+			// so this exercises ensureHeap's memory.grow path.
+			"heap growth past initial region",
+			"PUSH 500\nPUSH 42\nSTORE\nPUSH 500\nRETRIEVE\nPUTNUM\nFINISH\n",
+		},
+		{"negative jump", "PUSH -1\nJMP_NEG L0\nPUSH 1\nPUTNUM\nJMP L1\nMARK L0\nPUSH 2\nPUTNUM\nMARK L1\nFINISH\n"},
+		{"mul", "PUSH 6\nPUSH 7\nMUL\nPUTNUM\nFINISH\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			program := mustProgram(t, c.mnemonic)
+
+			module, err := Compile(*program)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			got := runModule(t, module)
+
+			var want bytes.Buffer
+			m := vm.NewMachine(false, bytes.NewReader(nil), &want)
+			if _, err := m.Run(context.Background(), program.Compile()); err != nil {
+				t.Fatalf("Machine.Run: %v", err)
+			}
+
+			if got != want.String() {
+				t.Fatalf("wasm output %q, interpreter output %q", got, want.String())
+			}
+		})
+	}
+}
+
+// TestCompileArithOverflowTraps checks that ADD/SUB/MUL trap under a real
+// WASM runtime instead of silently wrapping when two in-range int64
+// operands produce an out-of-range result: the scenario that motivated
+// compileArith's overflow checks was MaxInt64+1 wrapping to a negative
+// number rather than diverging loudly from vm.Machine's bignum-promoting
+// Number.Add.
+func TestCompileArithOverflowTraps(t *testing.T) {
+	cases := []struct {
+		name     string
+		mnemonic string
+	}{
+		{"add overflow", "PUSH 9223372036854775807\nPUSH 1\nADD\nPUTNUM\nFINISH\n"},
+		{"sub overflow", "PUSH -9223372036854775808\nPUSH 1\nSUB\nPUTNUM\nFINISH\n"},
+		{"mul overflow", "PUSH 9223372036854775807\nPUSH 2\nMUL\nPUTNUM\nFINISH\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			module, err := mustCompile(t, c.mnemonic)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if err := runModuleExpectTrap(t, module); err == nil {
+				t.Fatalf("run: expected a trap on overflow")
+			}
+		})
+	}
+}
+
+// TestCompileRecursionOverflowTraps checks that recursion deep enough to
+// overflow the fixed-size frame-stack region traps cleanly under a real WASM
+// runtime instead of silently corrupting the heap region that follows it in
+// linear memory: the scenario that motivated checkOverflow's frame-stack
+// check was a ~3000-deep recursive countdown running past frameCells
+// (1024) and landing on the wrong answer with no error at all, while the
+// identical program completes normally under vm.Machine.Run because Frame
+// grows without a fixed bound.
+func TestCompileRecursionOverflowTraps(t *testing.T) {
+	// count(n) returns 0 by recursing n deep (CALL without an intervening
+	// RET builds one frame per call), so the interpreter and a hypothetical
+	// unbounded wasm module would both print "0" -- the compiled module
+	// should instead trap once recursion depth passes frameCells.
+	const mnemonic = `
+PUSH 3000
+CALL L0
+PUTNUM
+FINISH
+MARK L0
+DUP
+JMP_IF0 L1
+PUSH 1
+SUB
+CALL L0
+MARK L1
+RET
+`
+	program := mustProgram(t, mnemonic)
+
+	var want bytes.Buffer
+	m := vm.NewMachine(false, bytes.NewReader(nil), &want)
+	if _, err := m.Run(context.Background(), program.Compile()); err != nil {
+		t.Fatalf("Machine.Run: %v", err)
+	}
+	if want.String() != "0" {
+		t.Fatalf("interpreter output = %q, want \"0\"", want.String())
+	}
+
+	module, err := Compile(*program)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if err := runModuleExpectTrap(t, module); err == nil {
+		t.Fatalf("run: expected a trap on frame-stack overflow")
+	}
+}